@@ -3,129 +3,95 @@ package main
 import (
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
+	"math/rand"
 	"os"
 	"time"
 
+	"github.com/KimBrusevold/webTimer/internal/config"
 	"github.com/KimBrusevold/webTimer/internal/database"
-	"github.com/joho/godotenv"
+	"github.com/KimBrusevold/webTimer/internal/password"
+	_ "github.com/tursodatabase/libsql-client-go/libsql"
+	_ "modernc.org/sqlite" // this dependency for running libsql from a db file
 )
 
-type settings struct {
-	hostUrl            string
-	dbUrl              string
-	tursoAuthToken     string
-	port               string
-	senderEmailAddress string
-	emailPassword      string
-}
-
+// Fills the database from the configuration with a few confirmed users and
+// their times. Only the database settings are needed.
 func main() {
-	settings := getEnvSettings()
-
-	connStr := buildConnectionString(settings)
-
-	db, err := sql.Open("libsql", connStr)
-	pingErr := db.Ping()
-	log.Printf("Opening and pinging %s", connStr)
+	cfg, err := config.Load(os.Args[1:])
+	if cfg == nil {
+		os.Exit(2)
+	}
+	if err == nil {
+		err = cfg.Database.Validate()
+	}
 	if err != nil {
-		panic(pingErr)
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%s\n", err)
+		os.Exit(1)
 	}
+
+	db, err := sql.Open("libsql", cfg.Database.ConnectionString())
 	if err != nil {
-		log.Fatalf("Could not create connector to database: %s", err)
+		fatal("Could not create connector to database", "err", err)
 	}
 	defer db.Close()
+	if err := db.Ping(); err != nil {
+		fatal("Could not reach database", "err", err)
+	}
 
 	timerDb := database.NewDbTimerRepository(db)
 
-	createMockUsers(timerDb)
-	createMockTimes(db)
+	userIds := createMockUsers(timerDb, cfg.Passwords)
+	createMockTimes(db, userIds)
 }
 
-func createMockTimes(timerDb *sql.DB) {
+func createMockTimes(db *sql.DB, userIds []int64) {
 	location := time.Now().Location()
 	startTime := time.Date(2024, 03, 1, 0, 0, 0, 0, location).UTC()
 
-	for i := 1; i <= 3; i++ {
-		userid := i + 1
+	for _, userid := range userIds {
 		for i := 0; i < 10; i++ {
-			timerDb.Exec(`INSERT INTO times()`)
+			start := startTime.Add(time.Duration(i) * 24 * time.Hour)
+			computed := int64(60_000 + rand.Intn(60_000))
+			_, err := db.Exec(`INSERT INTO times(userid, starttime, endtime, computedtime, courseid)
+				values(?, ?, ?, ?, (SELECT min(id) FROM courses))`,
+				userid, start.UnixMilli(), start.UnixMilli()+computed, computed)
+			if err != nil {
+				fatal("Could not create mock time", "userId", userid, "err", err)
+			}
 		}
 	}
+	slog.Info("Created mock times", "users", len(userIds))
 }
 
-func createMockUsers(db *database.TimerDB) {
-	db.CreateUser(database.User{
-		Username: "testuser1",
-		Email:    "test@email.com",
-		Password: "1234",
-	})
-
-	db.CreateUser(database.User{
-		Username: "trappesønn",
-		Email:    "trapp@gmail.com",
-		Password: "1234",
-	})
-	db.CreateUser(database.User{
-		Username: "sjefen",
-		Email:    "serius@business.com",
-		Password: "1234",
-	})
-}
-
-func getEnvSettings() settings {
-	if err := godotenv.Load(); err != nil {
-		log.Print("No .env file found")
-	} else {
-		log.Print("Loaded variables from .env file")
-	}
-
-	hostUrl, exists := os.LookupEnv("HOSTURL")
-	if !exists {
-		log.Fatal("No rnv variable named 'HOSTURL' in .env file or environment variable. Exiting")
+// The users follow the same password policy as signup.
+func createMockUsers(db *database.TimerDB, passwords password.Policy) []int64 {
+	users := []database.User{
+		{Username: "testuser1", Email: "test@email.com", Password: "trappeløp-test"},
+		{Username: "trappesønn", Email: "trapp@gmail.com", Password: "trappeløp-test"},
+		{Username: "sjefen", Email: "serius@business.com", Password: "trappeløp-test"},
 	}
 
-	dbUrl, exists := os.LookupEnv("DATABASE_URL")
-	if !exists {
-		log.Fatal("No env variable named 'DATABASE_URL' in .env file or environment variable. Exiting")
-	}
-	authToken, exists := os.LookupEnv("TURSO_AUTH_TOKEN")
-	if !exists {
-		log.Fatal("No env variable named 'TURSO_AUTH_TOKEN' in .env file or environment variable. Exiting")
-	}
-
-	senderEmail, exists := os.LookupEnv("EMAIL_SENDER_ADDRESS")
-	if !exists || senderEmail == "" {
-		log.Fatal("No env variable or emtpy value named 'EMAIL_SENDER_ADDRESS' in .env file or environment variable. Exiting")
-	}
-
-	emailPassword, exists := os.LookupEnv("EMAIL_PASSWORD")
-	if !exists || emailPassword == "" {
-		log.Fatal("No env variable or emtpy value named 'EMAIL_PASSWORD' in .env file or environment variable. Exiting")
-	}
-
-	port, exists := os.LookupEnv("PORT")
-	if !exists {
-		log.Println("No port set. Using default: 8080")
-		port = "8080"
-	}
-
-	return settings{
-		hostUrl:            hostUrl,
-		dbUrl:              dbUrl,
-		tursoAuthToken:     authToken,
-		port:               port,
-		senderEmailAddress: senderEmail,
-		emailPassword:      emailPassword,
+	var ids []int64
+	for _, user := range users {
+		if err := passwords.Validate(user.Password); err != nil {
+			fatal("Mock user password is refused by the password policy", "username", user.Username, "err", err)
+		}
+		created, err := db.CreateUser(user)
+		if err != nil {
+			fatal("Could not create mock user", "username", user.Username, "err", err)
+		}
+		user.OneTimeCode = created.OneTimeCode
+		if err := db.ConfirmOneTimeCode(user, database.Confirmed); err != nil {
+			fatal("Could not confirm mock user", "username", user.Username, "err", err)
+		}
+		ids = append(ids, created.ID)
 	}
+	slog.Info("Created mock users", "count", len(ids))
+	return ids
 }
 
-func buildConnectionString(s settings) string {
-	var connString string
-	if s.tursoAuthToken == "" {
-		connString = s.dbUrl
-	} else {
-		connString = s.dbUrl + fmt.Sprintf("?authToken=%s", s.tursoAuthToken)
-	}
-	return connString
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
 }
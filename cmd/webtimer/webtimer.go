@@ -1,19 +1,36 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"database/sql"
+	"errors"
 	"fmt"
-	"log"
+	"io/fs"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/KimBrusevold/webTimer/internal/achievements"
+	"github.com/KimBrusevold/webTimer/internal/assets"
+	"github.com/KimBrusevold/webTimer/internal/challenges"
+	"github.com/KimBrusevold/webTimer/internal/config"
 	"github.com/KimBrusevold/webTimer/internal/database"
 	"github.com/KimBrusevold/webTimer/internal/email"
+	"github.com/KimBrusevold/webTimer/internal/goals"
 	"github.com/KimBrusevold/webTimer/internal/handler"
 	"github.com/KimBrusevold/webTimer/internal/handler/auth"
+	"github.com/KimBrusevold/webTimer/internal/logging"
+	"github.com/KimBrusevold/webTimer/internal/magiclink"
+	"github.com/KimBrusevold/webTimer/internal/metrics"
+	"github.com/KimBrusevold/webTimer/internal/middelware"
+	"github.com/KimBrusevold/webTimer/internal/oidc"
+	"github.com/KimBrusevold/webTimer/internal/ratelimit"
+	"github.com/KimBrusevold/webTimer/web"
 	"github.com/gin-gonic/gin"
-	"github.com/joho/godotenv"
 
 	_ "github.com/tursodatabase/libsql-client-go/libsql"
 	_ "modernc.org/sqlite" // this dependency for running libsql from a db file
@@ -21,66 +38,233 @@ import (
 
 var timerDb *database.TimerDB
 
-type settings struct {
-	hostUrl            string
-	dbUrl              string
-	tursoAuthToken     string
-	port               string
-	senderEmailAddress string
-	emailPassword      string
-}
-
 func main() {
-	settings := getEnvSettings()
+	args := os.Args[1:]
+	if len(args) >= 2 && args[0] == "config" && args[1] == "print" {
+		os.Exit(printConfig(args[2:]))
+	}
 
-	connStr := buildConnectionString(settings)
+	cfg, err := config.Load(args)
+	if cfg == nil {
+		os.Exit(2)
+	}
+	if err := errors.Join(err, cfg.Validate()); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%s\n", err)
+		os.Exit(1)
+	}
 
-	db, err := sql.Open("libsql", connStr)
-	pingErr := db.Ping()
-	log.Printf("Opening and pinging %s", connStr)
+	logger, err := logging.New(os.Stderr, cfg.LogLevel, cfg.LogFormat)
 	if err != nil {
-		panic(pingErr)
+		fmt.Fprintf(os.Stderr, "Could not set up logging: %s\n", err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
+
+	loginLinkSecret := []byte(cfg.LoginLinkSecret)
+	if len(loginLinkSecret) == 0 {
+		slog.Warn("No LOGIN_LINK_SECRET set. Using a random secret, login links will stop working on restart")
+		loginLinkSecret = make([]byte, 32)
+		if _, err := rand.Read(loginLinkSecret); err != nil {
+			fatal("Could not create login link secret", "err", err)
+		}
 	}
+
+	var appMetrics *metrics.Metrics
+	if cfg.Metrics {
+		appMetrics = metrics.New()
+	}
+
+	connStr := cfg.Database.ConnectionString()
+
+	db, err := sql.Open("libsql", connStr)
 	if err != nil {
-		log.Fatalf("Could not create connector to database: %s", err)
+		fatal("Could not create connector to database", "err", err)
 	}
+	// Reopen through the driver wrapped by metrics, which times every query
+	libsql := db.Driver()
+	db.Close()
+	db = sql.OpenDB(appMetrics.Connector(libsql, connStr))
 	defer db.Close()
 
+	slog.Info("Opening and pinging database", "url", cfg.Database.URL)
+	if err := db.Ping(); err != nil {
+		fatal("Could not reach the database", "err", err)
+	}
+
 	timerDb = database.NewDbTimerRepository(db)
+	timerDb.Codes = cfg.OneTimeCodes
+	appMetrics.WatchTimers(timerDb, 2*time.Hour)
+
+	cookies, err := middelware.NewCookieConfig(cfg.HostURL)
+	if err != nil {
+		fatal("Invalid value for 'HOSTURL'", "err", err)
+	}
+	securityHeaders := &middelware.SecurityHeadersMiddelware{HSTS: cookies.Secure}
+	csrf := &middelware.CSRFMiddelware{Cookies: cookies}
+
+	var webFiles fs.FS = web.Files
+	if cfg.WebDir != "" {
+		slog.Info("Reading templates and static files from disk", "dir", cfg.WebDir)
+		webFiles = os.DirFS(cfg.WebDir)
+	}
+	webAssets, err := assets.New(webFiles, cfg.WebDir != "")
+	if err != nil {
+		fatal("Could not load templates and static files", "err", err)
+	}
+
+	r := gin.New()
+	r.HTMLRender = webAssets
+	// The client ip is what requests are rate limited on, so it is only taken
+	// from X-Forwarded-For when that is set by a known proxy
+	if err := r.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		fatal("Could not set trusted proxies", "err", err)
+	}
+
+	// Registered before the middleware, so probes are not logged or counted
+	health := &handler.HealthHandler{
+		DB: timerDb,
+	}
+	health.SetupRoutes(r.Group(""))
+
+	metricsMW := &middelware.MetricsMiddelware{Metrics: appMetrics}
+	r.Use(middelware.RequestID, gin.Recovery(), metricsMW.Observe, securityHeaders.Apply, csrf.Protect)
+	if appMetrics != nil {
+		r.GET("/metrics", gin.WrapH(appMetrics.Handler()))
+	}
+
+	achievementEngine := achievements.Engine{
+		DB:       timerDb,
+		Calendar: cfg.Streaks,
+	}
 
-	r := gin.Default()
-	r.LoadHTMLGlob("./web/pages/template/**/*")
+	emailClient := &email.EmailClient{
+		HostAddr:   "smtp.gmail.com",
+		SenderAddr: cfg.Email.SenderAddress, // A gmail address
+		Password:   cfg.Email.Password,      // A gmail app key
+	}
+	goalTracker := goals.Tracker{
+		DB:          timerDb,
+		EmailClient: emailClient,
+	}
+	challengeService := challenges.Service{
+		DB:          timerDb,
+		EmailClient: emailClient,
+	}
 
 	lh := handler.LeaderboardHandler{
+		DB:           timerDb,
+		Achievements: achievementEngine,
+		Goals:        goalTracker,
+	}
+
+	authMW := middelware.AuthMiddelware{
+		DB: timerDb,
+	}
+	leaderboards := r.Group("", authMW.Identify)
+	leaderboards.GET("/", lh.HandleLeaderboardShow)
+	leaderboards.GET("/leaderboard/raskest", lh.RenderFastestLeaderboard)
+	leaderboards.GET("/leaderboard/flest", lh.RenderMostLeaderboard)
+	leaderboards.GET("/leaderboard/fremgang", lh.RenderImprovedLeaderboard)
+	leaderboards.GET("/leaderboard/pa-rad", lh.RenderStreakLeaderboard)
+	leaderboards.GET("/leaderboard/klasse", lh.RenderCategoryLeaderboard)
+	leaderboards.GET("/leaderboard/handikap", lh.RenderHandicapLeaderboard)
+
+	achievementsH := handler.AchievementsHandler{
 		DB: timerDb,
 	}
+	achievementsH.SetupRoutes(r.Group("/merker", authMW.Identify))
 
-	r.GET("/", lh.HandleLeaderboardShow)
-	r.GET("/leaderboard/raskest", lh.RenderFastestLeaderboard)
-	r.GET("/leaderboard/flest", lh.RenderMostLeaderboard)
+	statsH := handler.StatsHandler{
+		DB: timerDb,
+	}
+	statsH.SetupRoutes(r.Group("/statistikk", authMW.Identify))
 
-	r.Static("/res/images", "./web/static/images")
-	r.Static("/res/css", "./web/static/css")
-	r.Static("/res/scripts", "./web/static/scripts")
+	r.GET("/res/*file", webAssets.Static)
+	r.GET("/favicon.ico", webAssets.File("images/upstairs.png"))
 
-	r.StaticFile("/favicon.ico", "./web/static/images/upstairs.png")
+	outbox := &email.Outbox{
+		DB:          timerDb,
+		Client:      emailClient,
+		Interval:    15 * time.Second,
+		Backoff:     time.Minute,
+		MaxAttempts: 8,
+		Expiry:      24 * time.Hour,
+		Metrics:     appMetrics,
+	}
+	emailClient.Outbox = outbox
+	workers, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	outboxDone := make(chan struct{})
+	go func() {
+		outbox.Run(workers)
+		close(outboxDone)
+	}()
 
+	limitStore := ratelimit.NewMemoryStore()
 	authHandler := auth.AuthHandler{
-		DB: timerDb,
-		EmailClient: &email.EmailClient{
-			HostAddr:   "smtp.gmail.com",
-			SenderAddr: settings.senderEmailAddress, // A gmail address
-			Password:   settings.emailPassword,      // A gmail app key
+		DB:          timerDb,
+		EmailClient: emailClient,
+		LoginLinks: magiclink.Signer{
+			Secret: loginLinkSecret,
+			TTL:    15 * time.Minute,
 		},
+		HostUrl: cfg.HostURL,
+		Cookies: cookies,
+		RateLimit: &middelware.RateLimitMiddelware{
+			Store:      limitStore,
+			PerIP:      ratelimit.Rate{Burst: 60, Per: 10 * time.Minute},
+			PerAccount: ratelimit.Rate{Burst: 10, Per: 10 * time.Minute},
+		},
+		Lockout: ratelimit.Lockout{
+			Store: limitStore,
+			Rate:  ratelimit.Rate{Burst: 5, Per: 15 * time.Minute},
+		},
+		Signup:    cfg.Signup,
+		Passwords: cfg.Passwords,
+		Metrics:   appMetrics,
+	}
+	if cfg.OIDC.Enabled() {
+		sso := cfg.OIDC
+		sso.RedirectURL = middelware.BaseUrl(cfg.HostURL) + "/aut/sso/callback"
+		authHandler.SSO, err = oidc.New(context.Background(), sso)
+		if err != nil {
+			fatal("Could not set up single sign-on", "err", err)
+		}
+		authHandler.SSOName = cfg.OIDCName
 	}
 	authHandler.SetupRoutes(r.Group("/aut"))
+	authHandler.SetupProfileRoutes(r.Group("/profil"))
+
+	adminH := handler.AdminHandler{
+		DB:          timerDb,
+		Outbox:      outbox,
+		EmailClient: emailClient,
+		Goals:       goalTracker,
+	}
+	adminH.SetupRoutes(r.Group("/admin"))
 
 	timerH := handler.TimerHandler{
-		DB: timerDb,
+		DB:           timerDb,
+		Metrics:      appMetrics,
+		Achievements: achievementEngine,
+		Goals:        goalTracker,
+		Challenges:   challengeService,
 	}
 	timerH.SetupRoutes(r.Group("/timer"))
 
-	addr := fmt.Sprintf("0.0.0.0:%s", settings.port)
+	challengesH := handler.ChallengesHandler{
+		DB:         timerDb,
+		Challenges: challengeService,
+	}
+	challengesH.SetupRoutes(r.Group("/utfordringer"))
+
+	heatsH := handler.HeatsHandler{
+		DB:      timerDb,
+		Metrics: appMetrics,
+	}
+	heatsH.SetupRoutes(r.Group("/heat"))
+
+	addr := fmt.Sprintf("0.0.0.0:%s", cfg.Port)
 
 	srv := &http.Server{
 		Handler: r,
@@ -89,67 +273,61 @@ func main() {
 		WriteTimeout: 15 * time.Second,
 		ReadTimeout:  15 * time.Second,
 	}
-	log.Printf("Now listening on %s", addr)
+	stopSignal, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	err = srv.ListenAndServe()
-
-	log.Printf("Shutting down server %s", err.Error())
-	os.Exit(0)
-}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.ListenAndServe()
+	}()
+	slog.Info("Now listening", "addr", addr)
 
-func getEnvSettings() settings {
-	if err := godotenv.Load(); err != nil {
-		log.Print("No .env file found")
-	} else {
-		log.Print("Loaded variables from .env file")
+	select {
+	case err := <-serveErr:
+		fatal("Server stopped", "err", err)
+	case <-stopSignal.Done():
 	}
+	stop() // A second signal kills the process right away
 
-	hostUrl, exists := os.LookupEnv("HOSTURL")
-	if !exists {
-		log.Fatal("No rnv variable named 'HOSTURL' in .env file or environment variable. Exiting")
-	}
-
-	dbUrl, exists := os.LookupEnv("DATABASE_URL")
-	if !exists {
-		log.Fatal("No env variable named 'DATABASE_URL' in .env file or environment variable. Exiting")
-	}
-	authToken, exists := os.LookupEnv("TURSO_AUTH_TOKEN")
-	if !exists {
-		log.Fatal("No env variable named 'TURSO_AUTH_TOKEN' in .env file or environment variable. Exiting")
-	}
+	slog.Info("Shutting down server", "delay", cfg.ShutdownDelay, "timeout", cfg.ShutdownTimeout)
+	// Shutdown refuses new connections at once, so the orchestrator is given time
+	// to see readyz fail and stop sending requests first
+	health.Stopping()
+	time.Sleep(cfg.ShutdownDelay)
+	shutdown, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
 
-	senderEmail, exists := os.LookupEnv("EMAIL_SENDER_ADDRESS")
-	if !exists || senderEmail == "" {
-		log.Fatal("No env variable or emtpy value named 'EMAIL_SENDER_ADDRESS' in .env file or environment variable. Exiting")
+	if err := srv.Shutdown(shutdown); err != nil {
+		slog.Error("Could not finish all requests before shutting down", "err", err)
 	}
 
-	emailPassword, exists := os.LookupEnv("EMAIL_PASSWORD")
-	if !exists || emailPassword == "" {
-		log.Fatal("No env variable or emtpy value named 'EMAIL_PASSWORD' in .env file or environment variable. Exiting")
+	// Emails queued by the last requests are kept in the database and sent on the next start
+	stopWorkers()
+	select {
+	case <-outboxDone:
+	case <-shutdown.Done():
+		slog.Error("Email outbox did not stop before shutting down")
 	}
+	slog.Info("Server stopped")
+}
 
-	port, exists := os.LookupEnv("PORT")
-	if !exists {
-		log.Println("No port set. Using default: 8080")
-		port = "8080"
+// printConfig shows the settings the server would start with, for
+// "webtimer config print".
+func printConfig(args []string) int {
+	cfg, err := config.Load(args)
+	if cfg == nil {
+		return 2
 	}
-
-	return settings{
-		hostUrl:            hostUrl,
-		dbUrl:              dbUrl,
-		tursoAuthToken:     authToken,
-		port:               port,
-		senderEmailAddress: senderEmail,
-		emailPassword:      emailPassword,
+	cfg.Print(os.Stdout)
+	if err := errors.Join(err, cfg.Validate()); err != nil {
+		fmt.Fprintf(os.Stderr, "\nInvalid configuration:\n%s\n", err)
+		return 1
 	}
+	return 0
 }
 
-func buildConnectionString(s settings) string {
-	var connString string
-	if s.tursoAuthToken == "" {
-		connString = s.dbUrl
-	} else {
-		connString = s.dbUrl + fmt.Sprintf("?authToken=%s", s.tursoAuthToken)
-	}
-	return connString
+// fatal logs the error and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
 }
@@ -0,0 +1,185 @@
+// Package achievements decides which badges users have earned with their runs.
+//
+// Badges are checked against all the finished runs of the user each time they
+// finish one, so a new badge is also given to users who earned it before it
+// was added.
+package achievements
+
+import (
+	"log/slog"
+	"time"
+	_ "time/tzdata" // The image has no time zone database
+
+	"github.com/KimBrusevold/webTimer/internal/database"
+)
+
+// Days and hours are counted in Norwegian time.
+var location = loadLocation("Europe/Oslo")
+
+type Badge struct {
+	ID          string // Stored in the database, never change it
+	Name        string
+	Description string
+	Icon        string
+	earned      func(timers []database.Timer) bool
+}
+
+// Badges are shown in this order.
+var Badges = []Badge{
+	{ID: "first-run", Name: "Første løp", Description: "Fullførte et løp", Icon: "👟", earned: runs(1)},
+	{ID: "runs-10", Name: "10 løp", Description: "Fullførte 10 løp", Icon: "🥉", earned: runs(10)},
+	{ID: "runs-50", Name: "50 løp", Description: "Fullførte 50 løp", Icon: "🥈", earned: runs(50)},
+	{ID: "runs-100", Name: "100 løp", Description: "Fullførte 100 løp", Icon: "🥇", earned: runs(100)},
+	{ID: "sub-2-minutes", Name: "Under 2 minutter", Description: "Løp på under 2 minutter", Icon: "⚡", earned: fasterThan(2 * time.Minute)},
+	{ID: "workweek", Name: "Hele uka", Description: "Løp hver arbeidsdag fra mandag til fredag i samme uke", Icon: "📅", earned: everyWorkday},
+	{ID: "personal-best", Name: "Ny rekord", Description: "Slo sin egen beste tid", Icon: "🚀", earned: personalBest},
+	{ID: "early-bird", Name: "Morgenfugl", Description: "Startet et løp før klokka 08:00", Icon: "🐦", earned: startedBefore(8)},
+}
+
+// Engine gives users the badges they have earned, and counts their streaks.
+type Engine struct {
+	DB       *database.TimerDB
+	Calendar Calendar
+}
+
+// RunFinished checks the runs of the user after they have finished one, and
+// returns the badges that are new.
+func (e Engine) RunFinished(userID int64) ([]Badge, error) {
+	timers, err := e.DB.FinishedTimers(userID)
+	if err != nil {
+		return nil, err
+	}
+	awarded, err := e.DB.AwardAchievements(userID, Earned(timers), time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return Lookup(awarded), nil
+}
+
+// Earned gives the ids of the badges earned with the finished timers.
+func Earned(timers []database.Timer) []string {
+	var ids []string
+	for _, b := range Badges {
+		if b.earned(timers) {
+			ids = append(ids, b.ID)
+		}
+	}
+	return ids
+}
+
+// Lookup gives the badges with the ids, in the order of Badges. Unknown ids are
+// left out.
+func Lookup(ids []string) []Badge {
+	var badges []Badge
+	for _, b := range Badges {
+		for _, id := range ids {
+			if b.ID == id {
+				badges = append(badges, b)
+				break
+			}
+		}
+	}
+	return badges
+}
+
+// Summary is a badge with the users who have earned it.
+type Summary struct {
+	Badge
+	Holders []string
+	Earned  bool // By the user looking at it
+}
+
+// Summarize lists every badge with the users in all who have earned it. The
+// badges in mine are marked as earned.
+func Summarize(all []database.Achievement, mine []database.Achievement) []Summary {
+	summaries := make([]Summary, len(Badges))
+	for i, b := range Badges {
+		summaries[i].Badge = b
+		for _, a := range all {
+			if a.Badge == b.ID {
+				summaries[i].Holders = append(summaries[i].Holders, a.Username)
+			}
+		}
+		for _, a := range mine {
+			if a.Badge == b.ID {
+				summaries[i].Earned = true
+			}
+		}
+	}
+	return summaries
+}
+
+func runs(n int) func([]database.Timer) bool {
+	return func(timers []database.Timer) bool {
+		return len(timers) >= n
+	}
+}
+
+func fasterThan(d time.Duration) func([]database.Timer) bool {
+	return func(timers []database.Timer) bool {
+		for _, t := range timers {
+			if t.ComputedTime.Int64 < d.Milliseconds() {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func startedBefore(hour int) func([]database.Timer) bool {
+	return func(timers []database.Timer) bool {
+		for _, t := range timers {
+			if start(t).Hour() < hour {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// A run faster than the first one means the record has been beaten at least
+// once. The first run doesn't count as a record.
+func personalBest(timers []database.Timer) bool {
+	if len(timers) == 0 {
+		return false
+	}
+	best := timers[0].ComputedTime.Int64
+	for _, t := range timers[1:] {
+		if t.ComputedTime.Int64 < best {
+			return true
+		}
+	}
+	return false
+}
+
+func everyWorkday(timers []database.Timer) bool {
+	days := map[string]bool{}
+	for _, t := range timers {
+		days[start(t).Format(time.DateOnly)] = true
+	}
+
+	for _, t := range timers {
+		week := monday(start(t))
+		all := true
+		for i := 0; i < 5 && all; i++ {
+			all = days[week.AddDate(0, 0, i).Format(time.DateOnly)]
+		}
+		if all {
+			return true
+		}
+	}
+	return false
+}
+
+func start(t database.Timer) time.Time {
+	return time.UnixMilli(t.StartTime).In(location)
+}
+
+func loadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		slog.Error("Could not load time zone, using UTC", "name", name, "err", err)
+		return time.UTC
+	}
+	return loc
+}
@@ -0,0 +1,160 @@
+package achievements
+
+import (
+	"sort"
+	"time"
+
+	"github.com/KimBrusevold/webTimer/internal/database"
+)
+
+// Calendar decides which days count for streaks. Days that don't count neither
+// add to a streak nor break it.
+type Calendar struct {
+	SkipWeekends bool
+	Holidays     []string // Dates like 2024-12-24
+}
+
+// Streak is how many days and weeks in a row the user has finished a run. A
+// streak is still active when the run for today or this week is missing, as
+// there is still time for it.
+type Streak struct {
+	Days  int
+	Weeks int
+}
+
+type StreakPlace struct {
+	Place    int
+	Username string
+	Length   int
+	Badges   []Badge
+}
+
+// Streaks gives the active streaks from the start times of finished runs, in
+// unix milliseconds.
+func (c Calendar) Streaks(starts []int64, now time.Time) Streak {
+	if len(starts) == 0 {
+		return Streak{}
+	}
+	days := map[string]bool{}
+	weeks := map[string]bool{}
+	first := now
+	for _, ms := range starts {
+		s := time.UnixMilli(ms).In(location)
+		days[s.Format(time.DateOnly)] = true
+		weeks[monday(s).Format(time.DateOnly)] = true
+		if s.Before(first) {
+			first = s
+		}
+	}
+	first = day(first)
+
+	var streak Streak
+	today := day(now.In(location))
+	for d := today; !d.Before(first); d = d.AddDate(0, 0, -1) {
+		if !c.counts(d) {
+			continue
+		}
+		if days[d.Format(time.DateOnly)] {
+			streak.Days++
+		} else if !d.Equal(today) {
+			break
+		}
+	}
+
+	thisWeek := monday(today)
+	for w := thisWeek; !w.Before(monday(first)); w = w.AddDate(0, 0, -7) {
+		if !c.countsWeek(w) {
+			continue
+		}
+		if weeks[w.Format(time.DateOnly)] {
+			streak.Weeks++
+		} else if !w.Equal(thisWeek) {
+			break
+		}
+	}
+	return streak
+}
+
+func (c Calendar) counts(d time.Time) bool {
+	if c.SkipWeekends && (d.Weekday() == time.Saturday || d.Weekday() == time.Sunday) {
+		return false
+	}
+	date := d.Format(time.DateOnly)
+	for _, h := range c.Holidays {
+		if h == date {
+			return false
+		}
+	}
+	return true
+}
+
+// A week counts if any of its days count, so a week of holidays is skipped.
+func (c Calendar) countsWeek(monday time.Time) bool {
+	for i := 0; i < 7; i++ {
+		if c.counts(monday.AddDate(0, 0, i)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Streak gives the active streaks of the user.
+func (e Engine) Streak(userID int64) (Streak, error) {
+	timers, err := e.DB.FinishedTimers(userID)
+	if err != nil {
+		return Streak{}, err
+	}
+	return e.Calendar.Streaks(startTimes(timers), time.Now()), nil
+}
+
+// StreakLeaderboard ranks the users the viewer can see by their active streak
+// of days, or of weeks when weeks is true. Users without a streak are left out.
+func (e Engine) StreakLeaderboard(v database.Viewer, weeks bool) ([]StreakPlace, error) {
+	runs, err := e.DB.RetrieveRunStarts(v)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var places []StreakPlace
+	for _, r := range runs {
+		streak := e.Calendar.Streaks(r.Starts, now)
+		length := streak.Days
+		if weeks {
+			length = streak.Weeks
+		}
+		if length == 0 {
+			continue
+		}
+		places = append(places, StreakPlace{
+			Username: r.Username,
+			Length:   length,
+			Badges:   Lookup(r.Badges),
+		})
+	}
+
+	sort.SliceStable(places, func(i, j int) bool {
+		return places[i].Length > places[j].Length
+	})
+	for i := range places {
+		places[i].Place = i + 1
+	}
+	return places, nil
+}
+
+func startTimes(timers []database.Timer) []int64 {
+	starts := make([]int64, len(timers))
+	for i, t := range timers {
+		starts[i] = t.StartTime
+	}
+	return starts
+}
+
+func day(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+func monday(t time.Time) time.Time {
+	return day(t).AddDate(0, 0, -(int(t.Weekday())+6)%7)
+}
@@ -0,0 +1,197 @@
+// Package assets renders the templates and serves the static files.
+//
+// Static files are linked from templates with {{ asset "css/style.css" }},
+// which gives a url with a hash of the file in its name. The hashed urls are
+// cached for a year, and a changed file gets a new url.
+//
+// In dev mode nothing is cached: templates are parsed on every render and files
+// are read on every request, so changes on disk show up right away.
+package assets
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/render"
+)
+
+const (
+	templates = "pages/template/*/*"
+	static    = "static"
+	urlPrefix = "/res/"
+)
+
+var ErrUnknownAsset = errors.New("unknown asset")
+
+type Assets struct {
+	fsys      fs.FS // With pages/template and static, like the web folder
+	static    fs.FS // The static folder, so urls can not reach the files beside it
+	dev       bool
+	hashed    map[string]string // Name of the file in static to the name with its hash
+	files     map[string]string // Name with the hash to the name of the file
+	templates *template.Template
+}
+
+// New reads the templates and static files in fsys. In dev mode they are read
+// again on each use instead.
+func New(fsys fs.FS, dev bool) (*Assets, error) {
+	staticFS, err := fs.Sub(fsys, static)
+	if err != nil {
+		return nil, fmt.Errorf("could not read static files: %w", err)
+	}
+	a := &Assets{
+		fsys:   fsys,
+		static: staticFS,
+		dev:    dev,
+		hashed: map[string]string{},
+		files:  map[string]string{},
+	}
+
+	t, err := a.parse()
+	if err != nil {
+		return nil, err
+	}
+	if dev {
+		return a, nil
+	}
+	a.templates = t
+
+	err = fs.WalkDir(fsys, static, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		b, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+		name := strings.TrimPrefix(p, static+"/")
+		sum := sha256.Sum256(b)
+		ext := path.Ext(name)
+		withHash := strings.TrimSuffix(name, ext) + "." + hex.EncodeToString(sum[:4]) + ext
+		a.hashed[name] = withHash
+		a.files[withHash] = name
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not read static files: %w", err)
+	}
+	return a, nil
+}
+
+func (a *Assets) parse() (*template.Template, error) {
+	t, err := template.New("").Funcs(template.FuncMap{"asset": a.Path}).ParseFS(a.fsys, templates)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse templates: %w", err)
+	}
+	return t, nil
+}
+
+// Path gives the url of the static file name, like css/style.css.
+func (a *Assets) Path(name string) (string, error) {
+	if a.dev {
+		if _, err := fs.Stat(a.static, name); err != nil {
+			return "", fmt.Errorf("%w: %s", ErrUnknownAsset, name)
+		}
+		return urlPrefix + name, nil
+	}
+	withHash, ok := a.hashed[name]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownAsset, name)
+	}
+	return urlPrefix + withHash, nil
+}
+
+// Instance lets gin render the templates, see gin.Engine.HTMLRender.
+func (a *Assets) Instance(name string, data any) render.Render {
+	t := a.templates
+	if a.dev {
+		var err error
+		t, err = a.parse()
+		if err != nil {
+			slog.Error("Could not reload templates", "err", err)
+			return errorRender{err}
+		}
+	}
+	return render.HTML{Template: t, Name: name, Data: data}
+}
+
+// Static serves the static files under the url given by Path. Expects the
+// route to have a *file parameter.
+func (a *Assets) Static(c *gin.Context) {
+	a.serve(c, strings.TrimPrefix(c.Param("file"), "/"))
+}
+
+// File serves the static file name, for urls that can not change, like
+// /favicon.ico.
+func (a *Assets) File(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		a.serve(c, name)
+	}
+}
+
+func (a *Assets) serve(c *gin.Context, name string) {
+	if !fs.ValidPath(name) || strings.Contains(name, "..") {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	cache := "no-cache"
+	if original, ok := a.files[name]; ok {
+		name = original
+		cache = "public, max-age=31536000, immutable"
+	} else if withHash, ok := a.hashed[name]; ok {
+		// Not hashed in the url, so the browser must ask if it has changed
+		c.Header("ETag", `"`+withHash+`"`)
+	}
+
+	f, err := a.static.Open(name)
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil || stat.IsDir() {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		b, err := io.ReadAll(f)
+		if err != nil {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		content = bytes.NewReader(b)
+	}
+	c.Header("Cache-Control", cache)
+	http.ServeContent(c.Writer, c.Request, name, stat.ModTime(), content)
+}
+
+type errorRender struct {
+	err error
+}
+
+// Render shows the error, as it only happens in dev mode.
+func (r errorRender) Render(w http.ResponseWriter) error {
+	r.WriteContentType(w)
+	w.WriteHeader(http.StatusInternalServerError)
+	io.WriteString(w, r.err.Error())
+	return r.err
+}
+
+func (r errorRender) WriteContentType(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+}
@@ -0,0 +1,134 @@
+// Package challenges runs the duels where a user challenges a colleague to
+// beat their best time on a course before a deadline.
+//
+// The challenger may keep running while the challenge is open, and the time to
+// beat drops when they beat their own best. The opponent wins with the first
+// run after accepting that beats it, and the challenger wins if the deadline
+// passes first. Both are emailed at each step.
+package challenges
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/KimBrusevold/webTimer/internal/database"
+	"github.com/KimBrusevold/webTimer/internal/email"
+	"github.com/KimBrusevold/webTimer/internal/model"
+)
+
+var ErrSelf = errors.New("users cannot challenge themselves")
+
+type Service struct {
+	DB          *database.TimerDB
+	EmailClient *email.EmailClient
+}
+
+// Challenge lets the challenger challenge the user with the username to beat
+// their best time on the course before the deadline.
+func (s Service) Challenge(ctx context.Context, challengerID int64, opponent string, courseID int64, deadline time.Time) (database.Challenge, error) {
+	opponentID, err := s.DB.ConfirmedUserIdByUsername(opponent)
+	if err != nil {
+		return database.Challenge{}, err
+	}
+	if opponentID == challengerID {
+		return database.Challenge{}, ErrSelf
+	}
+	target, err := s.DB.BestTime(challengerID, courseID)
+	if err != nil {
+		return database.Challenge{}, err
+	}
+
+	id, err := s.DB.CreateChallenge(database.Challenge{
+		ChallengerID: challengerID,
+		OpponentID:   opponentID,
+		CourseID:     courseID,
+		Target:       target,
+		Created:      time.Now().UnixMilli(),
+		Deadline:     deadline.UnixMilli(),
+	})
+	if err != nil {
+		return database.Challenge{}, err
+	}
+	c, err := s.DB.RetrieveChallenge(id)
+	if err != nil {
+		return database.Challenge{}, err
+	}
+
+	err = s.EmailClient.SendChallengeInvite(c.OpponentEmail, c.Challenger, c.Course, model.FormatTime(c.Target), model.FormatDate(c.Deadline))
+	if err != nil {
+		slog.ErrorContext(ctx, "Could not queue challenge email", "challengeId", c.ID, "err", err)
+	}
+	return c, nil
+}
+
+// Answer accepts or declines a challenge to the user, and lets the challenger
+// know.
+func (s Service) Answer(ctx context.Context, id int64, userID int64, accept bool) error {
+	c, err := s.DB.AnswerChallenge(id, userID, accept, time.Now())
+	if err != nil {
+		return err
+	}
+	if err := s.EmailClient.SendChallengeAnswer(c.ChallengerEmail, c.Opponent, accept); err != nil {
+		slog.ErrorContext(ctx, "Could not queue challenge answer email", "challengeId", c.ID, "err", err)
+	}
+	return nil
+}
+
+// RunFinished resolves the challenges of the user after they have finished a
+// run, and ends the challenges that are past their deadline.
+func (s Service) RunFinished(ctx context.Context, userID int64) error {
+	if err := s.Expire(ctx); err != nil {
+		return err
+	}
+	run, err := s.DB.LastFinishedTimer(userID)
+	if err != nil {
+		return err
+	}
+
+	won, err := s.DB.BeatChallenges(run, time.Now())
+	if err != nil {
+		return err
+	}
+	for _, c := range won {
+		s.announce(ctx, c)
+	}
+
+	raised, err := s.DB.RaiseChallenges(run)
+	if err != nil {
+		return err
+	}
+	for _, c := range raised {
+		if c.State != database.ChallengeAccepted {
+			continue
+		}
+		if err := s.EmailClient.SendChallengeRaised(c.OpponentEmail, c.Challenger, c.Course, model.FormatTime(c.Target)); err != nil {
+			slog.ErrorContext(ctx, "Could not queue challenge raised email", "challengeId", c.ID, "err", err)
+		}
+	}
+	return nil
+}
+
+// Expire ends the challenges that are past their deadline.
+func (s Service) Expire(ctx context.Context) error {
+	ended, err := s.DB.ExpireChallenges(time.Now())
+	if err != nil {
+		return err
+	}
+	for _, c := range ended {
+		s.announce(ctx, c)
+	}
+	return nil
+}
+
+// Emails both the winner and the loser.
+func (s Service) announce(ctx context.Context, c database.Challenge) {
+	challengerWon := c.WinnerID.Int64 == c.ChallengerID
+	if err := s.EmailClient.SendChallengeResult(c.ChallengerEmail, c.Opponent, c.Course, challengerWon); err != nil {
+		slog.ErrorContext(ctx, "Could not queue challenge result email", "challengeId", c.ID, "err", err)
+	}
+	if err := s.EmailClient.SendChallengeResult(c.OpponentEmail, c.Challenger, c.Course, !challengerWon); err != nil {
+		slog.ErrorContext(ctx, "Could not queue challenge result email", "challengeId", c.ID, "err", err)
+	}
+}
@@ -0,0 +1,243 @@
+// Package config loads the settings shared by the server and the tools in cmd.
+//
+// Settings are read from, in increasing priority: defaults, a YAML or TOML file
+// given with -config or CONFIG_FILE, environment variables and a .env file, and
+// command line flags. Every setting has a key, like database.url, which is its
+// name in the file and its flag, and an environment variable, like DATABASE_URL.
+//
+// Problems are collected rather than returned one by one, so a broken setup can
+// be fixed in one go.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/KimBrusevold/webTimer/internal/achievements"
+	"github.com/KimBrusevold/webTimer/internal/database"
+	"github.com/KimBrusevold/webTimer/internal/oidc"
+	"github.com/KimBrusevold/webTimer/internal/password"
+	"github.com/KimBrusevold/webTimer/internal/signup"
+	"github.com/joho/godotenv"
+)
+
+type Config struct {
+	HostURL         string
+	Port            string
+	TrustedProxies  []string // Reverse proxies that may set X-Forwarded-For
+	Database        Database
+	Email           Email
+	LoginLinkSecret string
+	OneTimeCodes    database.OneTimeCodePolicy
+	Signup          signup.Policy
+	Passwords       password.Policy
+	Streaks         achievements.Calendar
+	OIDC            oidc.Config // RedirectURL is not a setting, the server builds it from HostURL
+	OIDCName        string
+	LogLevel        string
+	LogFormat       string
+	Metrics         bool
+	ShutdownTimeout time.Duration
+	ShutdownDelay   time.Duration // Wait after readyz starts failing, before no new connections are taken
+	WebDir          string        // Read templates and static files from here instead of the built in ones
+
+	sources map[string]string // Where each setting that is not a default came from
+}
+
+type Database struct {
+	URL       string
+	AuthToken string // Only needed for a remote Turso database
+}
+
+type Email struct {
+	SenderAddress string // A gmail address
+	Password      string // A gmail app key
+}
+
+func Default() *Config {
+	return &Config{
+		Port:         "8080",
+		OneTimeCodes: database.DefaultOneTimeCodePolicy,
+		Signup: signup.Policy{
+			Mode:    signup.Domains,
+			Domains: []string{"soprasteria.com"},
+		},
+		Passwords:       password.DefaultPolicy,
+		Streaks:         achievements.Calendar{SkipWeekends: true},
+		OIDCName:        "jobbkontoen",
+		LogLevel:        "info",
+		LogFormat:       "text",
+		Metrics:         true,
+		ShutdownTimeout: 20 * time.Second,
+		ShutdownDelay:   5 * time.Second,
+		sources:         map[string]string{},
+	}
+}
+
+// Load reads the settings, with args being the command line flags. A flag
+// that can't be parsed gives a nil Config, as the flag package has already
+// shown the usage. Otherwise the Config is returned along with any problems
+// found while reading it. Load does not check that the settings make sense,
+// see Validate.
+func Load(args []string) (*Config, error) {
+	c := Default()
+	settings := c.settings()
+
+	fset := flag.NewFlagSet("webtimer", flag.ContinueOnError)
+	path := fset.String("config", "", "YAML or TOML file to read settings from (CONFIG_FILE)")
+	flags := map[string]string{}
+	for _, s := range settings {
+		key := s.key
+		fset.Func(key, fmt.Sprintf("%s (%s)", s.usage, s.env), func(v string) error {
+			flags[key] = v
+			return nil
+		})
+	}
+	if err := fset.Parse(args); err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	if fset.NArg() > 0 {
+		errs = append(errs, fmt.Errorf("unexpected argument %q", fset.Arg(0)))
+	}
+
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		errs = append(errs, fmt.Errorf("could not read .env: %w", err))
+	}
+
+	if *path == "" {
+		*path = os.Getenv("CONFIG_FILE")
+	}
+	if *path != "" {
+		values, err := readFile(*path)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		for _, s := range settings {
+			if v, ok := values[s.key]; ok {
+				errs = append(errs, c.apply(s, v, "file "+*path, s.key))
+				delete(values, s.key)
+			}
+		}
+		unknown := make([]string, 0, len(values))
+		for key := range values {
+			unknown = append(unknown, key)
+		}
+		sort.Strings(unknown)
+		for _, key := range unknown {
+			errs = append(errs, fmt.Errorf("unknown setting %q in %s", key, *path))
+		}
+	}
+
+	for _, s := range settings {
+		if v := os.Getenv(s.env); v != "" {
+			errs = append(errs, c.apply(s, v, "env", s.env))
+		}
+	}
+
+	for _, s := range settings {
+		if v, ok := flags[s.key]; ok {
+			errs = append(errs, c.apply(s, v, "flag", "-"+s.key))
+		}
+	}
+
+	return c, errors.Join(errs...)
+}
+
+func (c *Config) apply(s setting, v string, source string, name string) error {
+	if err := s.value.Set(v); err != nil {
+		return fmt.Errorf("invalid value for %s: %w", name, err)
+	}
+	c.sources[s.key] = source
+	return nil
+}
+
+// Validate checks everything the server needs.
+func (c *Config) Validate() error {
+	var errs []error
+	if c.HostURL == "" {
+		errs = append(errs, errors.New("hosturl (HOSTURL) is required"))
+	}
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("port (PORT) must be a number between 1 and 65535, not %q", c.Port))
+	}
+	errs = append(errs, c.Database.Validate())
+	if c.Email.SenderAddress == "" {
+		errs = append(errs, errors.New("email.sender (EMAIL_SENDER_ADDRESS) is required"))
+	}
+	if c.Email.Password == "" {
+		errs = append(errs, errors.New("email.password (EMAIL_PASSWORD) is required"))
+	}
+
+	if c.OneTimeCodes.TTL <= 0 {
+		errs = append(errs, errors.New("onetimecode.ttl (ONETIMECODE_TTL) must be longer than 0"))
+	}
+	if c.OneTimeCodes.MaxAttempts < 1 {
+		errs = append(errs, errors.New("onetimecode.maxattempts (ONETIMECODE_MAX_ATTEMPTS) must be at least 1"))
+	}
+	if c.OneTimeCodes.ResendCooldown < 0 {
+		errs = append(errs, errors.New("onetimecode.resendcooldown (ONETIMECODE_RESEND_COOLDOWN) can not be negative"))
+	}
+	if c.Signup.Mode == signup.Domains && len(c.Signup.Domains) == 0 {
+		errs = append(errs, errors.New("signup.domains (SIGNUP_DOMAINS) is required in domains mode"))
+	}
+	if c.Passwords.MinLength < 1 || c.Passwords.MinLength > password.MaxBytes {
+		errs = append(errs, fmt.Errorf("password.minlength (PASSWORD_MIN_LENGTH) must be between 1 and %d", password.MaxBytes))
+	}
+	for _, h := range c.Streaks.Holidays {
+		if _, err := time.Parse(time.DateOnly, h); err != nil {
+			errs = append(errs, fmt.Errorf("streak.holidays (STREAK_HOLIDAYS) must be dates like 2024-12-24, not %q", h))
+		}
+	}
+	if (c.OIDC.Issuer != "" || c.OIDC.ClientID != "" || c.OIDC.ClientSecret != "") && !c.OIDC.Enabled() {
+		errs = append(errs, errors.New("oidc.issuer (OIDC_ISSUER) and oidc.clientid (OIDC_CLIENT_ID) are both required for single sign-on"))
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		errs = append(errs, fmt.Errorf("log.level (LOG_LEVEL) must be debug, info, warn or error, not %q", c.LogLevel))
+	}
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		errs = append(errs, fmt.Errorf("log.format (LOG_FORMAT) must be text or json, not %q", c.LogFormat))
+	}
+	if c.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("shutdown.timeout (SHUTDOWN_TIMEOUT) must be longer than 0"))
+	}
+	if c.ShutdownDelay < 0 {
+		errs = append(errs, errors.New("shutdown.delay (SHUTDOWN_DELAY) can not be negative"))
+	}
+	if c.WebDir != "" {
+		if _, err := os.Stat(filepath.Join(c.WebDir, "pages", "template")); err != nil {
+			errs = append(errs, fmt.Errorf("web.dir (WEB_DIR) must be a folder with pages and static, like ./web, not %q", c.WebDir))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Validate checks what is needed to connect to the database.
+func (d Database) Validate() error {
+	if d.URL == "" {
+		return errors.New("database.url (DATABASE_URL) is required")
+	}
+	if d.AuthToken == "" && !strings.HasPrefix(d.URL, "file:") {
+		return errors.New("database.authtoken (TURSO_AUTH_TOKEN) is required for a remote database")
+	}
+	return nil
+}
+
+func (d Database) ConnectionString() string {
+	if d.AuthToken == "" {
+		return d.URL
+	}
+	return d.URL + "?authToken=" + d.AuthToken
+}
@@ -0,0 +1,197 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/KimBrusevold/webTimer/internal/signup"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+type setting struct {
+	key    string // Name in the config file, and the flag
+	env    string
+	usage  string
+	secret bool // Masked when printed
+	value  value
+}
+
+type value interface {
+	Set(string) error
+	String() string
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{key: "hosturl", env: "HOSTURL", usage: "Url or host the site is served from", value: stringValue{&c.HostURL}},
+		{key: "port", env: "PORT", usage: "Port to listen on", value: stringValue{&c.Port}},
+		{key: "trustedproxies", env: "TRUSTED_PROXIES", usage: "Comma separated addresses or CIDR ranges of reverse proxies whose X-Forwarded-For is trusted", value: listValue{&c.TrustedProxies}},
+		{key: "database.url", env: "DATABASE_URL", usage: "Turso database url, or file: for a local file", value: stringValue{&c.Database.URL}},
+		{key: "database.authtoken", env: "TURSO_AUTH_TOKEN", usage: "Turso auth token", secret: true, value: stringValue{&c.Database.AuthToken}},
+		{key: "email.sender", env: "EMAIL_SENDER_ADDRESS", usage: "Gmail address emails are sent from", value: stringValue{&c.Email.SenderAddress}},
+		{key: "email.password", env: "EMAIL_PASSWORD", usage: "Gmail app key", secret: true, value: stringValue{&c.Email.Password}},
+		{key: "loginlink.secret", env: "LOGIN_LINK_SECRET", usage: "Secret that signs login links", secret: true, value: stringValue{&c.LoginLinkSecret}},
+		{key: "onetimecode.ttl", env: "ONETIMECODE_TTL", usage: "How long a one-time code is valid", value: durationValue{&c.OneTimeCodes.TTL}},
+		{key: "onetimecode.maxattempts", env: "ONETIMECODE_MAX_ATTEMPTS", usage: "Wrong guesses before a one-time code is locked", value: intValue{&c.OneTimeCodes.MaxAttempts}},
+		{key: "onetimecode.resendcooldown", env: "ONETIMECODE_RESEND_COOLDOWN", usage: "Wait before a new one-time code can be sent", value: durationValue{&c.OneTimeCodes.ResendCooldown}},
+		{key: "signup.mode", env: "SIGNUP_MODE", usage: "Who can register: open, domains or invite", value: modeValue{&c.Signup.Mode}},
+		{key: "signup.domains", env: "SIGNUP_DOMAINS", usage: "Comma separated email domains that can register without an invite", value: listValue{&c.Signup.Domains}},
+		{key: "signup.requireapproval", env: "SIGNUP_REQUIRE_APPROVAL", usage: "New users must be approved by an admin", value: boolValue{&c.Signup.RequireApproval}},
+		{key: "password.minlength", env: "PASSWORD_MIN_LENGTH", usage: "Shortest password allowed", value: intValue{&c.Passwords.MinLength}},
+		{key: "streak.skipweekends", env: "STREAK_SKIP_WEEKENDS", usage: "Weekends neither add to nor break a streak of days", value: boolValue{&c.Streaks.SkipWeekends}},
+		{key: "streak.holidays", env: "STREAK_HOLIDAYS", usage: "Comma separated dates, like 2024-12-24, that neither add to nor break a streak", value: listValue{&c.Streaks.Holidays}},
+		{key: "oidc.issuer", env: "OIDC_ISSUER", usage: "OpenID Connect issuer for single sign-on", value: stringValue{&c.OIDC.Issuer}},
+		{key: "oidc.clientid", env: "OIDC_CLIENT_ID", usage: "OpenID Connect client id", value: stringValue{&c.OIDC.ClientID}},
+		{key: "oidc.clientsecret", env: "OIDC_CLIENT_SECRET", usage: "OpenID Connect client secret", secret: true, value: stringValue{&c.OIDC.ClientSecret}},
+		{key: "oidc.name", env: "OIDC_NAME", usage: "Name of the identity provider, shown on the login button", value: stringValue{&c.OIDCName}},
+		{key: "log.level", env: "LOG_LEVEL", usage: "debug, info, warn or error", value: stringValue{&c.LogLevel}},
+		{key: "log.format", env: "LOG_FORMAT", usage: "text or json", value: stringValue{&c.LogFormat}},
+		{key: "metrics.enabled", env: "METRICS_ENABLED", usage: "Serve Prometheus metrics at /metrics", value: boolValue{&c.Metrics}},
+		{key: "shutdown.timeout", env: "SHUTDOWN_TIMEOUT", usage: "Longest wait for requests to finish when shutting down", value: durationValue{&c.ShutdownTimeout}},
+		{key: "shutdown.delay", env: "SHUTDOWN_DELAY", usage: "Wait between readyz failing and the server refusing new connections", value: durationValue{&c.ShutdownDelay}},
+		{key: "web.dir", env: "WEB_DIR", usage: "Folder to read templates and static files from on every request, for development", value: stringValue{&c.WebDir}},
+	}
+}
+
+// Print writes every setting with its value and where it came from. Secrets
+// are masked.
+func (c *Config) Print(w io.Writer) {
+	for _, s := range c.settings() {
+		v := s.value.String()
+		if s.secret && v != "" {
+			v = "********"
+		}
+		source, ok := c.sources[s.key]
+		if !ok {
+			source = "default"
+		}
+		fmt.Fprintf(w, "%-27s %-40s %s\n", s.key, v, source)
+	}
+}
+
+// readFile reads a YAML or TOML file, chosen by the extension, and returns its
+// settings by key. Nested tables give keys like database.url.
+func readFile(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read config file: %w", err)
+	}
+
+	raw := map[string]any{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &raw)
+	case ".toml":
+		err = toml.Unmarshal(b, &raw)
+	default:
+		return nil, fmt.Errorf("config file %s must end with .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not parse config file %s: %w", path, err)
+	}
+
+	values := map[string]string{}
+	flatten("", raw, values)
+	return values, nil
+}
+
+func flatten(prefix string, raw map[string]any, values map[string]string) {
+	for k, v := range raw {
+		key := strings.ToLower(prefix + k)
+		switch v := v.(type) {
+		case map[string]any:
+			flatten(key+".", v, values)
+		case []any:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			values[key] = strings.Join(items, ",")
+		default:
+			values[key] = fmt.Sprint(v)
+		}
+	}
+}
+
+type stringValue struct{ p *string }
+
+func (v stringValue) Set(s string) error {
+	*v.p = strings.TrimSpace(s)
+	return nil
+}
+
+func (v stringValue) String() string { return *v.p }
+
+type intValue struct{ p *int }
+
+func (v intValue) Set(s string) error {
+	i, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return fmt.Errorf("%q is not a number", s)
+	}
+	*v.p = i
+	return nil
+}
+
+func (v intValue) String() string { return strconv.Itoa(*v.p) }
+
+type boolValue struct{ p *bool }
+
+func (v boolValue) Set(s string) error {
+	b, err := strconv.ParseBool(strings.TrimSpace(s))
+	if err != nil {
+		return fmt.Errorf("%q is not true or false", s)
+	}
+	*v.p = b
+	return nil
+}
+
+func (v boolValue) String() string { return strconv.FormatBool(*v.p) }
+
+type durationValue struct{ p *time.Duration }
+
+func (v durationValue) Set(s string) error {
+	d, err := time.ParseDuration(strings.TrimSpace(s))
+	if err != nil {
+		return fmt.Errorf("%q is not a duration, like 15m", s)
+	}
+	*v.p = d
+	return nil
+}
+
+func (v durationValue) String() string { return v.p.String() }
+
+// listValue is comma separated.
+type listValue struct{ p *[]string }
+
+func (v listValue) Set(s string) error {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	*v.p = items
+	return nil
+}
+
+func (v listValue) String() string { return strings.Join(*v.p, ",") }
+
+type modeValue struct{ p *signup.Mode }
+
+func (v modeValue) Set(s string) error {
+	m, err := signup.ParseMode(s)
+	if err != nil {
+		return err
+	}
+	*v.p = m
+	return nil
+}
+
+func (v modeValue) String() string { return string(*v.p) }
@@ -0,0 +1,158 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// The badges of the user in a leaderboard query, as ids separated by commas, or
+// NULL. Read it with splitBadges.
+const badgeList = `(SELECT group_concat(badge) FROM userachievements WHERE userachievements.userid = users.id)`
+
+type RunStarts struct {
+	Username string // The name the viewer sees, see displayName
+	Badges   []string
+	Starts   []int64
+}
+
+type Achievement struct {
+	Badge    string
+	Username string // The name the viewer sees, see displayName
+	Earned   int64
+}
+
+// Get the finished timers of the user, oldest first.
+func (r *TimerDB) FinishedTimers(userID int64) ([]Timer, error) {
+	query := `SELECT id, userid, starttime, endtime, computedtime FROM times
+		WHERE userid = ? AND computedtime IS NOT NULL
+		ORDER BY starttime;`
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var timers []Timer
+	for rows.Next() {
+		var t Timer
+		if err := rows.Scan(&t.ID, &t.UserID, &t.StartTime, &t.EndTime, &t.ComputedTime); err != nil {
+			return timers, err
+		}
+		timers = append(timers, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return timers, err
+	}
+	return timers, nil
+}
+
+// Get the start times of the finished runs of every user the viewer can see.
+func (r *TimerDB) RetrieveRunStarts(v Viewer) ([]RunStarts, error) {
+	query := `SELECT users.id, ` + displayName + `, ` + badgeList + `, times.starttime FROM times
+		INNER JOIN users ON users.id = times.userid
+		WHERE ` + visibleTo + `
+		AND times.computedtime IS NOT NULL
+		ORDER BY users.id;`
+	rows, err := r.db.Query(query, v.args()...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []RunStarts
+	var lastID int64
+	for rows.Next() {
+		var id, start int64
+		var username string
+		var badges sql.NullString
+		if err := rows.Scan(&id, &username, &badges, &start); err != nil {
+			return runs, err
+		}
+		if len(runs) == 0 || id != lastID {
+			runs = append(runs, RunStarts{Username: username, Badges: splitBadges(badges)})
+			lastID = id
+		}
+		runs[len(runs)-1].Starts = append(runs[len(runs)-1].Starts, start)
+	}
+
+	if err = rows.Err(); err != nil {
+		return runs, err
+	}
+	return runs, nil
+}
+
+// Gives the user the badges they don't already have, and returns those.
+func (r *TimerDB) AwardAchievements(userID int64, badges []string, at time.Time) ([]string, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var awarded []string
+	command := `INSERT OR IGNORE INTO userachievements(userid, badge, earned) values(?, ?, ?);`
+	for _, badge := range badges {
+		res, err := tx.Exec(command, userID, badge, at.UTC().UnixMilli())
+		if err != nil {
+			return nil, err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			awarded = append(awarded, badge)
+		}
+	}
+	return awarded, tx.Commit()
+}
+
+// Get the badges of the user, with the ones earned first first.
+func (r *TimerDB) UserAchievements(userID int64) ([]Achievement, error) {
+	query := `SELECT badge, username, earned FROM userachievements
+		INNER JOIN users ON users.id = userachievements.userid
+		WHERE userid = ?
+		ORDER BY earned;`
+	return r.queryAchievements(query, userID)
+}
+
+// Get every badge that has been earned by someone the viewer can see, with
+// the ones earned first first.
+func (r *TimerDB) RetrieveAchievements(v Viewer) ([]Achievement, error) {
+	query := `SELECT badge, ` + displayName + `, earned FROM userachievements
+		INNER JOIN users ON users.id = userachievements.userid
+		WHERE ` + visibleTo + `
+		ORDER BY earned;`
+	return r.queryAchievements(query, v.args()...)
+}
+
+func (r *TimerDB) queryAchievements(query string, args ...any) ([]Achievement, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var achievements []Achievement
+	for rows.Next() {
+		var a Achievement
+		if err := rows.Scan(&a.Badge, &a.Username, &a.Earned); err != nil {
+			return achievements, err
+		}
+		achievements = append(achievements, a)
+	}
+
+	if err = rows.Err(); err != nil {
+		return achievements, err
+	}
+	return achievements, nil
+}
+
+func splitBadges(badges sql.NullString) []string {
+	if !badges.Valid || badges.String == "" {
+		return nil
+	}
+	return strings.Split(badges.String, ",")
+}
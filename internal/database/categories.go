@@ -0,0 +1,121 @@
+package database
+
+import (
+	"errors"
+	"slices"
+	"strings"
+)
+
+var ErrUnknownCategory = errors.New("category does not exist")
+
+type CategoryKind string
+
+const (
+	CategoryAgeGroup CategoryKind = "alder"
+	CategoryGender   CategoryKind = "kjonn"
+	CategoryDivision CategoryKind = "divisjon" // Made up by the users themselves
+)
+
+var (
+	AgeGroups = []string{"Under 30", "30-39", "40-49", "50-59", "60 og over"}
+	Genders   = []string{"Kvinne", "Mann", "Annet"}
+)
+
+// The column of each kind. Only these are put into queries.
+var categoryColumns = map[CategoryKind]string{
+	CategoryAgeGroup: "users.agegroup",
+	CategoryGender:   "users.gender",
+	CategoryDivision: "users.division",
+}
+
+// Category is a class of users that are ranked against each other. The zero
+// value is everyone.
+type Category struct {
+	Kind  CategoryKind
+	Value string
+}
+
+// ParseCategory reads a category written like alder:30-39. An empty string is
+// everyone.
+func ParseCategory(s string) (Category, error) {
+	if s == "" {
+		return Category{}, nil
+	}
+	kind, value, _ := strings.Cut(s, ":")
+	if _, ok := categoryColumns[CategoryKind(kind)]; !ok || value == "" {
+		return Category{}, ErrUnknownCategory
+	}
+	return Category{Kind: CategoryKind(kind), Value: value}, nil
+}
+
+func (c Category) String() string {
+	if c.Kind == "" {
+		return ""
+	}
+	return string(c.Kind) + ":" + c.Value
+}
+
+// The condition on users for the category, and its arguments.
+func (c Category) where() (string, []any, error) {
+	if c.Kind == "" {
+		return "1", nil, nil
+	}
+	column, ok := categoryColumns[c.Kind]
+	if !ok {
+		return "", nil, ErrUnknownCategory
+	}
+	return column + " = ?", []any{c.Value}, nil
+}
+
+// Sets the categories of the user. Empty ones are cleared. A division that
+// differs from an existing one only in case is spelled like the existing one,
+// so users end up in the same division.
+func (r *TimerDB) UpdateCategories(userID int64, ageGroup string, gender string, division string) error {
+	if ageGroup != "" && !slices.Contains(AgeGroups, ageGroup) {
+		return ErrUnknownCategory
+	}
+	if gender != "" && !slices.Contains(Genders, gender) {
+		return ErrUnknownCategory
+	}
+	division = strings.TrimSpace(division)
+	// lower() in SQLite only knows ASCII, so æ, ø and å are compared here
+	divisions, err := r.RetrieveDivisions()
+	if err != nil {
+		return err
+	}
+	for _, d := range divisions {
+		if strings.EqualFold(d, division) {
+			division = d
+			break
+		}
+	}
+
+	command := `UPDATE users SET agegroup = nullif(?, ''), gender = nullif(?, ''), division = nullif(?, '') WHERE id = ?;`
+	_, err = r.db.Exec(command, ageGroup, gender, division, userID)
+	return err
+}
+
+// Get the divisions users have chosen, by name.
+func (r *TimerDB) RetrieveDivisions() ([]string, error) {
+	query := `SELECT DISTINCT division FROM users WHERE division IS NOT NULL AND state = ?
+		ORDER BY division;`
+	rows, err := r.db.Query(query, Confirmed)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var divisions []string
+	for rows.Next() {
+		var d string
+		if err := rows.Scan(&d); err != nil {
+			return divisions, err
+		}
+		divisions = append(divisions, d)
+	}
+
+	if err = rows.Err(); err != nil {
+		return divisions, err
+	}
+	return divisions, nil
+}
@@ -0,0 +1,233 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNoBestTime       = errors.New("user has no finished run on the course")
+	ErrUnknownUser      = errors.New("user does not exist")
+	ErrChallengeNotOpen = errors.New("challenge is not waiting for an answer")
+)
+
+type ChallengeState int
+
+const (
+	ChallengePending  ChallengeState = 0
+	ChallengeAccepted ChallengeState = 1
+	ChallengeDeclined ChallengeState = 2
+	ChallengeFinished ChallengeState = 3 // Has a winner
+	ChallengeExpired  ChallengeState = 4 // Not answered before the deadline
+)
+
+// Challenge is a duel where the opponent tries to beat the best time of the
+// challenger on a course before the deadline. Times are in unix milliseconds.
+type Challenge struct {
+	ID              int64
+	ChallengerID    int64
+	Challenger      string
+	ChallengerEmail string
+	OpponentID      int64
+	Opponent        string
+	OpponentEmail   string
+	CourseID        int64
+	Course          string
+	Target          int64
+	State           ChallengeState
+	Created         int64
+	Deadline        int64
+	Accepted        sql.NullInt64
+	WinnerID        sql.NullInt64
+	WinningTime     sql.NullInt64
+	Resolved        sql.NullInt64
+}
+
+const challengeColumns = `challenges.id, challengerid, challenger.username, challenger.email,
+	opponentid, opponent.username, opponent.email, courseid, courses.name, target, challenges.state,
+	created, deadline, accepted, winnerid, winningtime, resolved`
+
+const challengeTables = `challenges
+	INNER JOIN users challenger ON challenger.id = challenges.challengerid
+	INNER JOIN users opponent ON opponent.id = challenges.opponentid
+	INNER JOIN courses ON courses.id = challenges.courseid`
+
+// Get the best time of the user on the course.
+func (r *TimerDB) BestTime(userID int64, courseID int64) (int64, error) {
+	var best sql.NullInt64
+	query := `SELECT min(computedtime) FROM times WHERE userid = ? AND courseid = ?;`
+	if err := r.db.QueryRow(query, userID, courseID).Scan(&best); err != nil {
+		return 0, err
+	}
+	if !best.Valid {
+		return 0, ErrNoBestTime
+	}
+	return best.Int64, nil
+}
+
+// Get the last run the user finished.
+func (r *TimerDB) LastFinishedTimer(userID int64) (Timer, error) {
+	query := `SELECT id, userid, courseid, starttime, endtime, computedtime FROM times
+		WHERE userid = ? AND computedtime IS NOT NULL
+		ORDER BY endtime DESC LIMIT 1;`
+	var t Timer
+	err := r.db.QueryRow(query, userID).Scan(&t.ID, &t.UserID, &t.CourseID, &t.StartTime, &t.EndTime, &t.ComputedTime)
+	return t, err
+}
+
+func (r *TimerDB) ConfirmedUserIdByUsername(username string) (int64, error) {
+	var id int64
+	command := `SELECT id FROM users WHERE lower(username) = lower(?) AND state = ?;`
+	err := r.db.QueryRow(command, strings.TrimSpace(username), Confirmed).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrUnknownUser
+	}
+	return id, err
+}
+
+func (r *TimerDB) CreateChallenge(c Challenge) (int64, error) {
+	command := `INSERT INTO challenges(challengerid, opponentid, courseid, target, state, created, deadline)
+		values(?, ?, ?, ?, ?, ?, ?) RETURNING id;`
+	err := r.db.QueryRow(command, c.ChallengerID, c.OpponentID, c.CourseID, c.Target, ChallengePending, c.Created, c.Deadline).Scan(&c.ID)
+	return c.ID, err
+}
+
+func (r *TimerDB) RetrieveChallenge(id int64) (Challenge, error) {
+	challenges, err := r.queryChallenges(`SELECT `+challengeColumns+` FROM `+challengeTables+` WHERE challenges.id = ?;`, id)
+	if err != nil {
+		return Challenge{}, err
+	}
+	if len(challenges) == 0 {
+		return Challenge{}, sql.ErrNoRows
+	}
+	return challenges[0], nil
+}
+
+// Get the challenges the user is in, the newest first.
+func (r *TimerDB) RetrieveChallenges(userID int64) ([]Challenge, error) {
+	query := `SELECT ` + challengeColumns + ` FROM ` + challengeTables + `
+		WHERE challengerid = ? OR opponentid = ?
+		ORDER BY created DESC, challenges.id DESC;`
+	return r.queryChallenges(query, userID, userID)
+}
+
+// Accepts or declines a challenge to the opponent. Returns
+// ErrChallengeNotOpen if it is not theirs to answer, answered already, or past
+// its deadline.
+func (r *TimerDB) AnswerChallenge(id int64, opponentID int64, accept bool, at time.Time) (Challenge, error) {
+	state, accepted := ChallengeDeclined, sql.NullInt64{}
+	if accept {
+		state, accepted = ChallengeAccepted, sql.NullInt64{Int64: at.UnixMilli(), Valid: true}
+	}
+
+	command := `UPDATE challenges SET state = ?, accepted = ?, resolved = ?
+		WHERE id = ? AND opponentid = ? AND state = ? AND deadline > ?;`
+	resolved := sql.NullInt64{Int64: at.UnixMilli(), Valid: !accept}
+	res, err := r.db.Exec(command, state, accepted, resolved, id, opponentID, ChallengePending, at.UnixMilli())
+	if err != nil {
+		return Challenge{}, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return Challenge{}, err
+	}
+	if n == 0 {
+		return Challenge{}, ErrChallengeNotOpen
+	}
+	return r.RetrieveChallenge(id)
+}
+
+// Lets the opponent win the accepted challenges on the course that the run
+// beat. Returns the challenges they won.
+func (r *TimerDB) BeatChallenges(run Timer, at time.Time) ([]Challenge, error) {
+	command := `UPDATE challenges SET state = ?, winnerid = opponentid, winningtime = ?, resolved = ?
+		WHERE opponentid = ? AND courseid = ? AND state = ? AND target > ?
+		AND accepted <= ? AND deadline > ?
+		RETURNING id;`
+	return r.updateChallenges(command, ChallengeFinished, run.ComputedTime.Int64, at.UnixMilli(),
+		run.UserID, run.CourseID, ChallengeAccepted, run.ComputedTime.Int64,
+		run.StartTime, run.StartTime)
+}
+
+// Lowers the target of the open challenges on the course when the challenger
+// beat their own time with the run. Returns the challenges that got harder.
+func (r *TimerDB) RaiseChallenges(run Timer) ([]Challenge, error) {
+	command := `UPDATE challenges SET target = ?
+		WHERE challengerid = ? AND courseid = ? AND state IN (?, ?) AND target > ?
+		AND created <= ? AND deadline > ?
+		RETURNING id;`
+	return r.updateChallenges(command, run.ComputedTime.Int64,
+		run.UserID, run.CourseID, ChallengePending, ChallengeAccepted, run.ComputedTime.Int64,
+		run.StartTime, run.StartTime)
+}
+
+// Ends the challenges that are past their deadline. The challenger wins the
+// accepted ones, which are returned, and the unanswered ones expire.
+func (r *TimerDB) ExpireChallenges(at time.Time) ([]Challenge, error) {
+	command := `UPDATE challenges SET state = ?, resolved = ? WHERE state = ? AND deadline <= ?;`
+	if _, err := r.db.Exec(command, ChallengeExpired, at.UnixMilli(), ChallengePending, at.UnixMilli()); err != nil {
+		return nil, err
+	}
+
+	command = `UPDATE challenges SET state = ?, winnerid = challengerid, resolved = ?
+		WHERE state = ? AND deadline <= ?
+		RETURNING id;`
+	return r.updateChallenges(command, ChallengeFinished, at.UnixMilli(), ChallengeAccepted, at.UnixMilli())
+}
+
+// Runs an update that returns the ids of the challenges it changed, and gets
+// those challenges.
+func (r *TimerDB) updateChallenges(command string, args ...any) ([]Challenge, error) {
+	rows, err := r.db.Query(command, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []any
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	query := `SELECT ` + challengeColumns + ` FROM ` + challengeTables + `
+		WHERE challenges.id IN (?` + strings.Repeat(", ?", len(ids)-1) + `);`
+	return r.queryChallenges(query, ids...)
+}
+
+func (r *TimerDB) queryChallenges(query string, args ...any) ([]Challenge, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var challenges []Challenge
+	for rows.Next() {
+		var c Challenge
+		err := rows.Scan(&c.ID, &c.ChallengerID, &c.Challenger, &c.ChallengerEmail,
+			&c.OpponentID, &c.Opponent, &c.OpponentEmail, &c.CourseID, &c.Course, &c.Target, &c.State,
+			&c.Created, &c.Deadline, &c.Accepted, &c.WinnerID, &c.WinningTime, &c.Resolved)
+		if err != nil {
+			return challenges, err
+		}
+		challenges = append(challenges, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return challenges, err
+	}
+	return challenges, nil
+}
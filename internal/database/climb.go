@@ -0,0 +1,78 @@
+package database
+
+import "time"
+
+// Climb sums the finished runs of a user, a team or everyone, with the floors
+// and height of the course each run was on.
+type Climb struct {
+	Name   string
+	Runs   int
+	Floors int
+	Meters float64
+}
+
+const climbSums = `count(times.id), coalesce(sum(courses.floors), 0), coalesce(sum(courses.heightmeters), 0)`
+
+const climbRuns = `times
+	INNER JOIN courses ON courses.id = times.courseid
+	INNER JOIN users ON users.id = times.userid`
+
+// Get what everyone climbed in runs started from and up to to. Everyone counts,
+// also users who hide their times, as the sum doesn't show who ran.
+func (r *TimerDB) RetrieveClimb(from time.Time, to time.Time) (Climb, error) {
+	query := `SELECT ` + climbSums + ` FROM ` + climbRuns + `
+		WHERE times.computedtime IS NOT NULL
+		AND times.starttime >= ?
+		AND times.starttime < ?;`
+	var c Climb
+	err := r.db.QueryRow(query, from.UnixMilli(), to.UnixMilli()).Scan(&c.Runs, &c.Floors, &c.Meters)
+	return c, err
+}
+
+// Get what each user the viewer can see climbed, most first.
+func (r *TimerDB) RetrieveClimbByUser(v Viewer, from time.Time, to time.Time) ([]Climb, error) {
+	query := `SELECT ` + displayName + `, ` + climbSums + ` FROM ` + climbRuns + `
+		WHERE ` + visibleTo + `
+		AND times.computedtime IS NOT NULL
+		AND times.starttime >= ?
+		AND times.starttime < ?
+		GROUP BY users.id
+		ORDER BY 4 DESC;`
+	return r.queryClimbs(query, append(v.args(), from.UnixMilli(), to.UnixMilli())...)
+}
+
+// Get what each team climbed, most first. Users without a team are left out, and
+// so are the runs of users the viewer can't see, or a team of one would show them.
+func (r *TimerDB) RetrieveClimbByTeam(v Viewer, from time.Time, to time.Time) ([]Climb, error) {
+	query := `SELECT teams.name, ` + climbSums + ` FROM ` + climbRuns + `
+		INNER JOIN teams ON teams.id = users.teamid
+		WHERE ` + visibleTo + `
+		AND times.computedtime IS NOT NULL
+		AND times.starttime >= ?
+		AND times.starttime < ?
+		GROUP BY teams.id
+		ORDER BY 4 DESC;`
+	return r.queryClimbs(query, append(v.visibleArgs(), from.UnixMilli(), to.UnixMilli())...)
+}
+
+func (r *TimerDB) queryClimbs(query string, args ...any) ([]Climb, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var climbs []Climb
+	for rows.Next() {
+		var c Climb
+		if err := rows.Scan(&c.Name, &c.Runs, &c.Floors, &c.Meters); err != nil {
+			return climbs, err
+		}
+		climbs = append(climbs, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return climbs, err
+	}
+	return climbs, nil
+}
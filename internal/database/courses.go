@@ -0,0 +1,147 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+var (
+	ErrUnknownCourse = errors.New("course does not exist")
+	ErrCourseTaken   = errors.New("course name is used by another course")
+	ErrTeamTaken     = errors.New("team name is used by another team")
+	ErrUnknownTeam   = errors.New("team does not exist")
+)
+
+type Course struct {
+	ID           int64
+	Name         string
+	Floors       int
+	HeightMeters float64
+}
+
+type Team struct {
+	ID      int64
+	Name    string
+	Members int
+}
+
+// Get all courses, the default course first.
+func (r *TimerDB) RetrieveCourses() ([]Course, error) {
+	rows, err := r.db.Query(`SELECT id, name, floors, heightmeters FROM courses ORDER BY id;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var courses []Course
+	for rows.Next() {
+		var c Course
+		if err := rows.Scan(&c.ID, &c.Name, &c.Floors, &c.HeightMeters); err != nil {
+			return courses, err
+		}
+		courses = append(courses, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return courses, err
+	}
+	return courses, nil
+}
+
+// Creates the course when its ID is 0, and updates it otherwise.
+func (r *TimerDB) SaveCourse(c Course) (int64, error) {
+	c.Name = strings.TrimSpace(c.Name)
+	var id int64
+	err := r.db.QueryRow(`SELECT id FROM courses WHERE lower(name) = lower(?) AND id != ?;`, c.Name, c.ID).Scan(&id)
+	if err == nil {
+		return 0, ErrCourseTaken
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+
+	if c.ID == 0 {
+		command := `INSERT INTO courses(name, floors, heightmeters) values(?, ?, ?) RETURNING id;`
+		err := r.db.QueryRow(command, c.Name, c.Floors, c.HeightMeters).Scan(&c.ID)
+		return c.ID, err
+	}
+
+	command := `UPDATE courses SET name = ?, floors = ?, heightmeters = ? WHERE id = ?;`
+	res, err := r.db.Exec(command, c.Name, c.Floors, c.HeightMeters, c.ID)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, ErrUnknownCourse
+	}
+	return c.ID, nil
+}
+
+// Get all teams by name, with how many users are on each.
+func (r *TimerDB) RetrieveTeams() ([]Team, error) {
+	query := `SELECT teams.id, teams.name, count(users.id) FROM teams
+		LEFT JOIN users ON users.teamid = teams.id
+		GROUP BY teams.id
+		ORDER BY teams.name;`
+	rows, err := r.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var teams []Team
+	for rows.Next() {
+		var t Team
+		if err := rows.Scan(&t.ID, &t.Name, &t.Members); err != nil {
+			return teams, err
+		}
+		teams = append(teams, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return teams, err
+	}
+	return teams, nil
+}
+
+func (r *TimerDB) CreateTeam(name string) error {
+	name = strings.TrimSpace(name)
+	var id int64
+	err := r.db.QueryRow(`SELECT id FROM teams WHERE lower(name) = lower(?);`, name).Scan(&id)
+	if err == nil {
+		return ErrTeamTaken
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	_, err = r.db.Exec(`INSERT INTO teams(name) values(?);`, name)
+	return err
+}
+
+// Puts the user on the team. A teamID of 0 takes the user off their team.
+func (r *TimerDB) UpdateTeam(userID int64, teamID int64) error {
+	if teamID == 0 {
+		_, err := r.db.Exec(`UPDATE users SET teamid = NULL WHERE id = ?;`, userID)
+		return err
+	}
+
+	command := `UPDATE users SET teamid = ? WHERE id = ? AND EXISTS (SELECT id FROM teams WHERE id = ?);`
+	res, err := r.db.Exec(command, teamID, userID, teamID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUnknownTeam
+	}
+	return nil
+}
@@ -1,9 +1,10 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"errors"
-	"log"
+	"log/slog"
 	"time"
 
 	"github.com/google/uuid"
@@ -11,50 +12,82 @@ import (
 )
 
 var (
-	ErrUpdateFailed = errors.New("Update failed")
+	ErrUpdateFailed   = errors.New("Update failed")
+	ErrLoginTokenUsed = errors.New("login token is expired or already used")
+	ErrTimerRunning   = errors.New("timer is already started")
 )
 
 type TimerDB struct {
-	db *sql.DB
+	db    *sql.DB
+	Codes OneTimeCodePolicy
 }
 
 func NewDbTimerRepository(db *sql.DB) *TimerDB {
 	return &TimerDB{
-		db: db,
+		db:    db,
+		Codes: DefaultOneTimeCodePolicy,
 	}
 }
 
-func (r *TimerDB) ConfirmOneTimeCode(user User) error {
+// Confirms the email of a new user, and moves it to state, which is
+// Confirmed or AwaitingApproval when an admin must approve new users.
+func (r *TimerDB) ConfirmOneTimeCode(user User, state int) error {
 	if !user.OneTimeCode.Valid {
-		log.Panic("User has no OneTimeCode set")
+		panic("User has no OneTimeCode set")
 	}
 
-	command := `SELECT id, password FROM users WHERE username = ? AND email = ? AND onetimecode= ?`
-	row := r.db.QueryRow(command, user.Username, user.Email, user.OneTimeCode.String)
+	command := `SELECT id, password FROM users
+		WHERE username = ? AND email = ? AND state = ?`
+	row := r.db.QueryRow(command, user.Username, user.Email, Created)
 
 	var hashedPassword string
 	err := row.Scan(&user.ID, &hashedPassword)
 	if err != nil {
-		log.Printf("Error when getting row values. %s", err)
+		slog.Warn("Error when getting row values", "err", err)
+		return err
+	}
+
+	if err := r.verifyOneTimeCode(user.ID, user.OneTimeCode.String); err != nil {
 		return err
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(user.Password)); err != nil {
-		log.Printf("Passwords are not the same. %s", err)
+		slog.Info("Passwords are not the same", "err", err)
 		return err
 	}
 
-	command = "UPDATE users SET onetimecode = null, state = 1 WHERE id = ?;"
-	_, err = r.db.Exec(command, user.ID)
+	command = "UPDATE users SET onetimecode = null, onetimecodesent = null, state = ? WHERE id = ?;"
+	_, err = r.db.Exec(command, state, user.ID)
 	if err != nil {
-		log.Printf("Could not update userstate. %s", err)
+		slog.Error("Could not update userstate", "err", err)
 		return err
 	}
 	return nil
 }
 
 func (r *TimerDB) CreateUser(user User) (User, error) {
-	uid := uuid.New()
+	return r.CreateUserWithInvite(user, "")
+}
+
+// Creates the user and uses up the invite code in one go. An empty invite code is not checked.
+func (r *TimerDB) CreateUserWithInvite(user User, invite string) (User, error) {
+	invite = inviteCode(invite)
+	code, hashedCode, err := newOneTimeCode()
+	if err != nil {
+		return User{}, err
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback()
+
+	if invite != "" {
+		if err := claimInvite(tx, invite); err != nil {
+			return User{}, err
+		}
+	}
 
 	command := `SELECT id FROM users WHERE username = ? AND email = ?`
 
@@ -62,26 +95,36 @@ func (r *TimerDB) CreateUser(user User) (User, error) {
 	if error != nil {
 		return User{}, error
 	}
-	row := r.db.QueryRow(command, user.Username, user.Email)
+	row := tx.QueryRow(command, user.Username, user.Email)
 
-	err := row.Scan(&user.ID)
+	err = row.Scan(&user.ID)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			command = `INSERT INTO users(username, email, password ,onetimecode, state)
-			values(?, ?, ?, ?, ?)
+			command = `INSERT INTO users(username, email, password ,onetimecode, onetimecodeexpires, onetimecodesent, state)
+			values(?, ?, ?, ?, ?, ?, ?)
 			RETURNING id;`
 			user.OneTimeCode = sql.NullString{
-				String: uid.String(),
+				String: code,
+				Valid:  true,
 			}
 			user.Password = string(password[:])
-			row = r.db.QueryRow(command, user.Username, user.Email, user.Password, user.OneTimeCode.String, Created)
+			now := time.Now().UTC()
+			row = tx.QueryRow(command, user.Username, user.Email, user.Password, hashedCode,
+				now.Add(r.Codes.TTL).UnixMilli(), now.UnixMilli(), Created)
 
 			err := row.Scan(&user.ID)
 			if err != nil {
 				return User{}, err
 			}
 
-			return user, nil
+			if invite != "" {
+				_, err = tx.Exec(`UPDATE invites SET usedby = ? WHERE code = ?`, user.ID, invite)
+				if err != nil {
+					return User{}, err
+				}
+			}
+
+			return user, tx.Commit()
 		}
 		return User{}, err
 	}
@@ -89,24 +132,37 @@ func (r *TimerDB) CreateUser(user User) (User, error) {
 }
 
 func (r *TimerDB) UpdatePassword(user User) error {
-	query := "UPDATE users SET password = ?, onetimecode = null, state = 1 WHERE email = ? AND username = ? AND onetimecode = ?"
+	command := `SELECT id FROM users
+		WHERE email = ? AND username = ? AND state = ?`
+	row := r.db.QueryRow(command, user.Email, user.Username, ResettingPasswrod)
+
+	if err := row.Scan(&user.ID); err != nil {
+		return err
+	}
+
+	if err := r.verifyOneTimeCode(user.ID, user.OneTimeCode.String); err != nil {
+		return err
+	}
+
+	query := "UPDATE users SET password = ?, onetimecode = null, onetimecodesent = null, state = 1 WHERE id = ?"
 	password, err := bcrypt.GenerateFromPassword([]byte(user.Password), 10)
 	if err != nil {
 		return err
 	}
-	_, err = r.db.Exec(query, password, user.Email, user.Username, user.OneTimeCode.String)
+	_, err = r.db.Exec(query, password, user.ID)
 
 	return err
 }
 
 func (r *TimerDB) GetUser(userid int64) (*User, error) {
-	command := `SELECT id, username, email, onetimecode FROM users WHERE id = ?;`
+	command := `SELECT id, username, email, password != '', onetimecode, privacy, alias, teamid, agegroup, gender, division FROM users WHERE id = ?;`
 
 	row := r.db.QueryRow(command, userid)
 
 	user := User{}
 
-	err := row.Scan(&user.ID, &user.Username, &user.Email, &user.OneTimeCode)
+	err := row.Scan(&user.ID, &user.Username, &user.Email, &user.HasPassword, &user.OneTimeCode, &user.Privacy, &user.Alias, &user.TeamID,
+		&user.AgeGroup, &user.Gender, &user.Division)
 	if err != nil {
 		return nil, err
 	}
@@ -115,17 +171,20 @@ func (r *TimerDB) GetUser(userid int64) (*User, error) {
 }
 
 func (r *TimerDB) UserExistsWithUsername(username string) (bool, error) {
-	command := `SELECT id FROM users WHERE username = ?`
-	row := r.db.QueryRow(command, username)
+	return nameInUse(r.db, username, 0)
+}
 
+// Checks if someone other than the user has the name as username or alias,
+// without regard to case, so no one can pass as someone else.
+func nameInUse(db queryer, name string, userID int64) (bool, error) {
+	command := `SELECT id FROM users WHERE (lower(username) = lower(?) OR lower(alias) = lower(?)) AND id != ?;`
 	var id int64
-	err := row.Scan(&id)
+	err := db.QueryRow(command, name, name, userID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return false, nil
-		} else {
-			return false, err
-		}
+		return false, err
 	}
 	return true, nil
 }
@@ -147,29 +206,29 @@ func (r *TimerDB) UserExistsWithEmail(email string) (bool, int64, error) {
 	return true, id, nil
 }
 
+// Sends a password reset code to a user. Users that have not confirmed their
+// email or are awaiting approval can not reset their password, as that would
+// confirm them.
 func (r *TimerDB) SetNewOnetimeCode(username string, email string) (string, error) {
-	command := `SELECT id FROM users WHERE email = ? and username = ?;`
-	row := r.db.QueryRow(command, email, username)
+	command := `SELECT id, onetimecodesent FROM users WHERE email = ? AND username = ? AND state IN (?, ?);`
+	row := r.db.QueryRow(command, email, username, Confirmed, ResettingPasswrod)
 
 	var id int64
-	if err := row.Scan(&id); err != nil {
+	var sent sql.NullInt64
+	if err := row.Scan(&id, &sent); err != nil {
 		return "", err
 	}
 
-	command = `UPDATE users SET 
-		onetimecode = ?,
-		authcode = NULL,
-		state = 2
-		WHERE id = ?;`
+	if !r.canResendOneTimeCode(sent) {
+		return "", ErrResendTooSoon
+	}
 
-	uid := uuid.New().String()
-	_, err := r.db.Exec(command, uid, id)
-	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		log.Print(errors.Is(err, sql.ErrNoRows))
+	_, err := r.db.Exec(`UPDATE users SET authcode = NULL WHERE id = ?;`, id)
+	if err != nil {
 		return "", err
 	}
 
-	return uid, nil
+	return r.setOneTimeCode(r.db, id, ResettingPasswrod)
 }
 
 func (r *TimerDB) UserAuthProcess(email string, password string) (*User, error) {
@@ -188,32 +247,125 @@ func (r *TimerDB) UserAuthProcess(email string, password string) (*User, error)
 		return nil, err
 	}
 
-	command = `UPDATE users SET 
+	authcode, err := newAuthcode(r.db, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	user.Authcode.String = authcode
+
+	return &user, err
+}
+
+func (r *TimerDB) ConfirmedUserIdByEmail(email string) (int64, error) {
+	command := `SELECT id FROM users WHERE email = ? AND state = 1;`
+
+	row := r.db.QueryRow(command, email)
+
+	var id int64
+	err := row.Scan(&id)
+	return id, err
+}
+
+func (r *TimerDB) CreateLoginToken(userID int64, nonce string, expires time.Time) error {
+	command := `INSERT INTO logintokens(userid, nonce, expires) values(?, ?, ?)`
+	_, err := r.db.Exec(command, userID, nonce, expires.UnixMilli())
+	return err
+}
+
+// Marks the login token as used and logs the user in. Fails with ErrLoginTokenUsed
+// if the token is unknown, expired or already used.
+func (r *TimerDB) ConsumeLoginToken(userID int64, nonce string, now time.Time) (*User, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	command := `UPDATE logintokens SET used = ?
+		WHERE userid = ? AND nonce = ? AND used IS NULL AND expires > ?;`
+	res, err := tx.Exec(command, now.UnixMilli(), userID, nonce, now.UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 0 {
+		return nil, ErrLoginTokenUsed
+	}
+
+	user := User{}
+	row := tx.QueryRow(`SELECT id, username, email FROM users WHERE id = ? AND state = 1;`, userID)
+	if err := row.Scan(&user.ID, &user.Username, &user.Email); err != nil {
+		return nil, err
+	}
+
+	authcode, err := newAuthcode(tx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	user.Authcode.String = authcode
+
+	return &user, tx.Commit()
+}
+
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+type queryer interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// Gives the user a new authcode, which is what the login cookie is checked against.
+func newAuthcode(db execer, userID int64) (string, error) {
+	command := `UPDATE users SET 
 		onetimecode = NULL,
-		authcode = ?
+		authcode = ?,
+		authcodecreated = ?
 		WHERE id = ?;`
 
 	uid := uuid.New().String()
-	_, err = r.db.Exec(command, uid, user.ID)
+	_, err := db.Exec(command, uid, time.Now().UTC().UnixMilli(), userID)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	user.Authcode.String = uid
-
-	return &user, err
+	return uid, nil
 }
 
 func (r *TimerDB) IsAuthorizedUser(authcode string, id int) bool {
-	command := `SELECT id WHERE id = ? AND authcode = ?`
+	command := `SELECT id FROM users WHERE id = ? AND authcode = ?`
 
 	row := r.db.QueryRow(command, id, authcode)
 	var resid int64
 	err := row.Scan(&resid)
 
-	return err != nil
+	return err == nil
+}
+
+func (r *TimerDB) IsAdmin(id int) (bool, error) {
+	command := `SELECT admin FROM users WHERE id = ?`
+
+	row := r.db.QueryRow(command, id)
+	var admin bool
+	if err := row.Scan(&admin); err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	return admin, nil
+}
+
+func (r *TimerDB) Ping(ctx context.Context) error {
+	return r.db.PingContext(ctx)
 }
 
-func (r *TimerDB) StartTimer(userId int) error {
+// Starts a timer for the user on the course, or on the first course when
+// courseID is 0. Returns ErrTimerRunning if one is already started, and then
+// that one keeps running.
+func (r *TimerDB) StartTimer(userId int, courseID int64) error {
 	startTime := time.Now().UTC().UnixMilli()
 
 	res := r.db.QueryRow(`SELECT count(id) FROM times WHERE userid = ? AND endtime IS NULL`, userId)
@@ -225,20 +377,27 @@ func (r *TimerDB) StartTimer(userId int) error {
 	var n int
 	err := res.Scan(&n)
 	if err != nil {
-		log.Printf("kunne ikke lese antall rader påvirket")
+		slog.Error("Could not count started times", "err", err)
 		return err
 	}
 	if n > 0 {
-		log.Printf("Antall tider startet: %d", n)
-		log.Print("Tid er allerede påbegynt")
-		return nil
+		slog.Info("Time is already started", "userId", userId, "started", n)
+		return ErrTimerRunning
 	}
 
-	command := `INSERT INTO times(starttime, userid) values(?,?)`
-	_, err = r.db.Exec(command, startTime, userId)
+	command := `INSERT INTO times(starttime, userid, courseid)
+		SELECT ?, ?, id FROM courses WHERE id = ? OR (? = 0 AND id = (SELECT min(id) FROM courses));`
+	result, err := r.db.Exec(command, startTime, userId, courseID, courseID)
+	if err != nil {
+		return err
+	}
+	inserted, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
+	if inserted == 0 {
+		return ErrUnknownCourse
+	}
 	return nil
 }
 
@@ -252,7 +411,7 @@ func (r *TimerDB) EndTimeTimer(userId int) (int64, error) {
 	if err != nil {
 		return -1, err
 	}
-	
+
 	endtime := time.Now().UTC().UnixMilli()
 	computed := endtime - startTime
 	_, err = r.db.Exec("UPDATE times SET endtime = ?, computedtime = ? WHERE id = ?", endtime, computed, id)
@@ -260,20 +419,37 @@ func (r *TimerDB) EndTimeTimer(userId int) (int64, error) {
 	return computed, err
 }
 
+// Counts timers that are started but not stopped. Those started before cutoff
+// are counted as abandoned.
+func (r *TimerDB) OpenTimers(cutoff time.Time) (int, int, error) {
+	query := `SELECT count(id), count(CASE WHEN starttime < ? THEN 1 END) FROM times WHERE endtime IS NULL;`
+	var open, abandoned int
+	if err := r.db.QueryRow(query, cutoff.UnixMilli()).Scan(&open, &abandoned); err != nil {
+		return 0, 0, err
+	}
+	return open - abandoned, abandoned, nil
+}
+
 type RetrieveTimesResponse struct {
 	Place        int
+	UserID       int64
 	Username     string
 	ComputedTime int64
+	Badges       []string
 }
 
-func (r *TimerDB) RetrieveAllTimeFastestTimes() ([]RetrieveTimesResponse, error) {
-	query := `SELECT ROW_NUMBER () OVER (ORDER BY times.computedtime ASC) rownum, min(times.computedtime), username FROM times 
+// Get the best time of each user. A courseID of 0 compares the runs on every
+// course.
+func (r *TimerDB) RetrieveAllTimeFastestTimes(v Viewer, courseID int64) ([]RetrieveTimesResponse, error) {
+	query := `SELECT ROW_NUMBER () OVER (ORDER BY times.computedtime ASC) rownum, min(times.computedtime), users.id, ` + displayName + `, ` + badgeList + ` FROM times 
 		INNER JOIN users on users.id = userid
-		WHERE times.computedtime IS NOT NULL
+		WHERE ` + visibleTo + `
+		AND (? = 0 OR times.courseid = ?)
+		AND times.computedtime IS NOT NULL
 		GROUP BY userid;`
-	rows, err := r.db.Query(query)
-	if err != nil {	
-		log.Printf("database query failed %s", err)
+	rows, err := r.db.Query(query, append(v.args(), courseID, courseID)...)
+	if err != nil {
+		slog.Error("database query failed", "err", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -282,9 +458,11 @@ func (r *TimerDB) RetrieveAllTimeFastestTimes() ([]RetrieveTimesResponse, error)
 
 	for rows.Next() {
 		var tim RetrieveTimesResponse
-		if err := rows.Scan(&tim.Place, &tim.ComputedTime, &tim.Username); err != nil {
+		var badges sql.NullString
+		if err := rows.Scan(&tim.Place, &tim.ComputedTime, &tim.UserID, &tim.Username, &badges); err != nil {
 			return times, err
 		}
+		tim.Badges = splitBadges(badges)
 
 		times = append(times, tim)
 	}
@@ -0,0 +1,116 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// newTestDB gives a database in memory with every migration applied.
+func newTestDB(t *testing.T) *TimerDB {
+	t.Helper()
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection to :memory: gets a database of its own
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	files, err := filepath.Glob("../../migrations/*.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		up, _, _ := strings.Cut(string(b), "-- +goose Down")
+		if _, err := db.Exec(up); err != nil {
+			t.Fatalf("%s: %v", f, err)
+		}
+	}
+	return NewDbTimerRepository(db)
+}
+
+func addUser(t *testing.T, r *TimerDB, username string, state int) {
+	t.Helper()
+	command := `INSERT INTO users(username, email, password, state) VALUES (?, ?, '', ?);`
+	if _, err := r.db.Exec(command, username, username+"@example.com", state); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func userState(t *testing.T, r *TimerDB, username string) int {
+	t.Helper()
+	var state int
+	if err := r.db.QueryRow(`SELECT state FROM users WHERE username = ?;`, username).Scan(&state); err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+func TestPasswordResetKeepsUserAwaitingApproval(t *testing.T) {
+	r := newTestDB(t)
+	addUser(t, r, "ola", AwaitingApproval)
+
+	_, err := r.SetNewOnetimeCode("ola", "ola@example.com")
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("SetNewOnetimeCode() error = %v, want %v", err, sql.ErrNoRows)
+	}
+
+	user := User{Username: "ola", Email: "ola@example.com", Password: "nytt-passord"}
+	user.OneTimeCode.String, user.OneTimeCode.Valid = "000000", true
+	if err := r.UpdatePassword(user); err == nil {
+		t.Fatal("UpdatePassword() succeeded for a user awaiting approval")
+	}
+	if state := userState(t, r, "ola"); state != AwaitingApproval {
+		t.Errorf("state = %d, want %d", state, AwaitingApproval)
+	}
+}
+
+func TestPasswordResetOfConfirmedUser(t *testing.T) {
+	r := newTestDB(t)
+	addUser(t, r, "kari", Confirmed)
+
+	code, err := r.SetNewOnetimeCode("kari", "kari@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := User{Username: "kari", Email: "kari@example.com", Password: "nytt-passord"}
+	user.OneTimeCode.String, user.OneTimeCode.Valid = code, true
+	if err := r.UpdatePassword(user); err != nil {
+		t.Fatal(err)
+	}
+	if state := userState(t, r, "kari"); state != Confirmed {
+		t.Errorf("state = %d, want %d", state, Confirmed)
+	}
+}
+
+func TestSignupWithLowerCaseInviteRecordsUser(t *testing.T) {
+	r := newTestDB(t)
+	addUser(t, r, "admin", Confirmed)
+	if err := r.CreateInvite("ABC123", 1, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := r.CreateUserWithInvite(User{Username: "per", Email: "per@example.com", Password: "passord"}, " abc123 ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var usedBy sql.NullInt64
+	if err := r.db.QueryRow(`SELECT usedby FROM invites WHERE code = 'ABC123';`).Scan(&usedBy); err != nil {
+		t.Fatal(err)
+	}
+	if !usedBy.Valid || usedBy.Int64 != user.ID {
+		t.Errorf("usedby = %v, want %d", usedBy, user.ID)
+	}
+}
@@ -0,0 +1,28 @@
+package database
+
+import "database/sql"
+
+// Get the run the user has started but not finished.
+func (r *TimerDB) OpenTimer(userID int64) (Timer, error) {
+	query := `SELECT id, userid, courseid, starttime FROM times
+		WHERE userid = ? AND endtime IS NULL
+		ORDER BY starttime DESC LIMIT 1;`
+	var t Timer
+	err := r.db.QueryRow(query, userID).Scan(&t.ID, &t.UserID, &t.CourseID, &t.StartTime)
+	return t, err
+}
+
+// Get the best time of the user on the course of the run, from the runs
+// started before it. Returns ErrNoBestTime if there are none.
+func (r *TimerDB) BestTimeBefore(run Timer) (int64, error) {
+	var best sql.NullInt64
+	query := `SELECT min(computedtime) FROM times
+		WHERE userid = ? AND courseid = ? AND starttime < ? AND id != ?;`
+	if err := r.db.QueryRow(query, run.UserID, run.CourseID, run.StartTime, run.ID).Scan(&best); err != nil {
+		return 0, err
+	}
+	if !best.Valid {
+		return 0, ErrNoBestTime
+	}
+	return best.Int64, nil
+}
@@ -0,0 +1,125 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrUnknownGoal = errors.New("goal does not exist")
+
+type GoalKind string
+
+const (
+	GoalRuns   GoalKind = "runs"
+	GoalMeters GoalKind = "meters"
+)
+
+// Goal is something everyone reaches together, like a number of runs or
+// meters climbed from Starts up to Ends.
+type Goal struct {
+	ID      int64
+	Name    string
+	Kind    GoalKind
+	Target  float64
+	Starts  int64
+	Ends    int64
+	Reached sql.NullInt64
+}
+
+func (r *TimerDB) CreateGoal(g Goal) (int64, error) {
+	command := `INSERT INTO goals(name, kind, target, starts, ends) values(?, ?, ?, ?, ?) RETURNING id;`
+	err := r.db.QueryRow(command, g.Name, g.Kind, g.Target, g.Starts, g.Ends).Scan(&g.ID)
+	return g.ID, err
+}
+
+func (r *TimerDB) DeleteGoal(id int64) error {
+	res, err := r.db.Exec(`DELETE FROM goals WHERE id = ?;`, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUnknownGoal
+	}
+	return nil
+}
+
+// Get all goals, the latest first.
+func (r *TimerDB) RetrieveGoals() ([]Goal, error) {
+	query := `SELECT id, name, kind, target, starts, ends, reached FROM goals
+		ORDER BY starts DESC, id DESC;`
+	return r.queryGoals(query)
+}
+
+// Get the goals that are running at the time, the ones ending first first.
+func (r *TimerDB) RetrieveActiveGoals(at time.Time) ([]Goal, error) {
+	query := `SELECT id, name, kind, target, starts, ends, reached FROM goals
+		WHERE starts <= ? AND ends > ?
+		ORDER BY ends, id;`
+	return r.queryGoals(query, at.UnixMilli(), at.UnixMilli())
+}
+
+// Marks the goal as reached. Returns false if it was reached already, so only
+// one caller announces it.
+func (r *TimerDB) MarkGoalReached(id int64, at time.Time) (bool, error) {
+	res, err := r.db.Exec(`UPDATE goals SET reached = ? WHERE id = ? AND reached IS NULL;`, at.UnixMilli(), id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// Get the email of every user with a confirmed account, also the ones
+// resetting their password.
+func (r *TimerDB) ConfirmedEmails() ([]string, error) {
+	query := `SELECT email FROM users WHERE state IN (?, ?) ORDER BY id;`
+	rows, err := r.db.Query(query, Confirmed, ResettingPasswrod)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var emails []string
+	for rows.Next() {
+		var e string
+		if err := rows.Scan(&e); err != nil {
+			return emails, err
+		}
+		emails = append(emails, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return emails, err
+	}
+	return emails, nil
+}
+
+func (r *TimerDB) queryGoals(query string, args ...any) ([]Goal, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var goals []Goal
+	for rows.Next() {
+		var g Goal
+		if err := rows.Scan(&g.ID, &g.Name, &g.Kind, &g.Target, &g.Starts, &g.Ends, &g.Reached); err != nil {
+			return goals, err
+		}
+		goals = append(goals, g)
+	}
+
+	if err = rows.Err(); err != nil {
+		return goals, err
+	}
+	return goals, nil
+}
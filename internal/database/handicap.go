@@ -0,0 +1,108 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// The handicap of a user is the average time of their last HandicapRuns runs
+// on a course. Users need MinHandicapRuns runs on the course to get one.
+const (
+	HandicapRuns    = 10
+	MinHandicapRuns = 3
+)
+
+var ErrNoHandicap = errors.New("user has too few runs for a handicap")
+
+type HandicapResponse struct {
+	Place        int
+	Username     string
+	Badges       []string
+	ComputedTime int64
+	Handicap     int64 // What the user had before the run
+}
+
+// Beat is how much faster than the handicap the run was, as a fraction. It is
+// negative when the run was slower.
+func (h HandicapResponse) Beat() float64 {
+	return 1 - float64(h.ComputedTime)/float64(h.Handicap)
+}
+
+// Get the current handicap of the user on the course in milliseconds.
+func (r *TimerDB) Handicap(userID int64, courseID int64) (int64, error) {
+	query := `SELECT count(*), avg(computedtime) FROM (
+		SELECT computedtime FROM times WHERE userid = ? AND courseid = ? AND computedtime IS NOT NULL
+		ORDER BY starttime DESC LIMIT ?);`
+	var runs int
+	var handicap sql.NullFloat64
+	if err := r.db.QueryRow(query, userID, courseID, HandicapRuns).Scan(&runs, &handicap); err != nil {
+		return 0, err
+	}
+	if runs < MinHandicapRuns {
+		return 0, ErrNoHandicap
+	}
+	return int64(handicap.Float64), nil
+}
+
+// Get the run on the course in the period that beat the handicap of the runner
+// the most, for each user the viewer can see. The handicap of a run is the
+// average of the runs of the user on the course before it, so runs before the
+// period count too. A courseID of 0 takes the runs on every course.
+func (r *TimerDB) RetrieveHandicapTimes(v Viewer, courseID int64, from time.Time, to time.Time) ([]HandicapResponse, error) {
+	query := `SELECT users.id, ` + displayName + `, ` + badgeList + `, runs.computedtime, runs.handicap FROM (
+			SELECT userid, courseid, starttime, computedtime,
+			avg(computedtime) OVER earlier AS handicap, count(*) OVER earlier AS earlierruns
+			FROM times WHERE computedtime IS NOT NULL
+			WINDOW earlier AS (PARTITION BY userid, courseid ORDER BY starttime
+				ROWS BETWEEN ` + strconv.Itoa(HandicapRuns) + ` PRECEDING AND 1 PRECEDING)
+		) runs
+		INNER JOIN users ON users.id = runs.userid
+		WHERE ` + visibleTo + `
+		AND (? = 0 OR runs.courseid = ?)
+		AND runs.earlierruns >= ?
+		AND runs.handicap > 0
+		AND runs.starttime >= ?
+		AND runs.starttime < ?;`
+	args := append(v.args(), courseID, courseID, MinHandicapRuns, from.UnixMilli(), to.UnixMilli())
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	best := map[int64]int{}
+	var times []HandicapResponse
+	for rows.Next() {
+		var id int64
+		var h HandicapResponse
+		var handicap float64
+		var badges sql.NullString
+		if err := rows.Scan(&id, &h.Username, &badges, &h.ComputedTime, &handicap); err != nil {
+			return times, err
+		}
+		h.Handicap = int64(handicap)
+		h.Badges = splitBadges(badges)
+
+		i, seen := best[id]
+		if !seen {
+			best[id] = len(times)
+			times = append(times, h)
+		} else if h.Beat() > times[i].Beat() {
+			times[i] = h
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return times, err
+	}
+
+	sort.SliceStable(times, func(i, j int) bool {
+		return times[i].Beat() > times[j].Beat()
+	})
+	for i := range times {
+		times[i].Place = i + 1
+	}
+	return times, nil
+}
@@ -0,0 +1,241 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUnknownHeat = errors.New("heat does not exist")
+	ErrHeatStarted = errors.New("heat has already started")
+	ErrEmptyHeat   = errors.New("heat has no participants")
+)
+
+// Heat is a group run where the organizer starts the timers of everyone who
+// joined with the code at once. Times are in unix milliseconds.
+type Heat struct {
+	ID          int64
+	Code        string
+	Name        string
+	CourseID    int64
+	Course      string
+	OrganizerID int64
+	Organizer   string
+	Created     int64
+	Started     sql.NullInt64
+}
+
+// HeatResult is the leg of one participant. ComputedTime is empty until they
+// have finished. Username is empty for participants that hide their times from
+// the viewer, who are still placed so the placings of the others are right.
+type HeatResult struct {
+	UserID       int64
+	Username     string
+	Started      bool
+	ComputedTime sql.NullInt64
+}
+
+const heatColumns = `heats.id, heats.code, heats.name, heats.courseid, courses.name,
+	heats.organizerid, organizer.username, heats.created, heats.started`
+
+const heatTables = `heats
+	INNER JOIN courses ON courses.id = heats.courseid
+	INNER JOIN users organizer ON organizer.id = heats.organizerid`
+
+// Codes are read out loud and typed on phones, so they are matched without
+// regard to case and surrounding spaces.
+func heatCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func (r *TimerDB) CreateHeat(h Heat) (int64, error) {
+	command := `INSERT INTO heats(code, name, courseid, organizerid, created)
+		SELECT ?, ?, id, ?, ? FROM courses WHERE id = ?
+		RETURNING id;`
+	err := r.db.QueryRow(command, heatCode(h.Code), h.Name, h.OrganizerID, h.Created, h.CourseID).Scan(&h.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrUnknownCourse
+	}
+	return h.ID, err
+}
+
+func (r *TimerDB) RetrieveHeat(code string) (Heat, error) {
+	heats, err := r.queryHeats(`SELECT `+heatColumns+` FROM `+heatTables+` WHERE heats.code = ?;`, heatCode(code))
+	if err != nil {
+		return Heat{}, err
+	}
+	if len(heats) == 0 {
+		return Heat{}, ErrUnknownHeat
+	}
+	return heats[0], nil
+}
+
+// Get the heats the user organizes or has joined, the newest first.
+func (r *TimerDB) RetrieveHeats(userID int64) ([]Heat, error) {
+	query := `SELECT ` + heatColumns + ` FROM ` + heatTables + `
+		WHERE heats.organizerid = ? OR heats.id IN (SELECT heatid FROM heatparticipants WHERE userid = ?)
+		ORDER BY heats.created DESC, heats.id DESC;`
+	return r.queryHeats(query, userID, userID)
+}
+
+// Adds the user to the heat with the code. Joining a heat twice does nothing.
+// Returns ErrHeatStarted once the heat is under way.
+func (r *TimerDB) JoinHeat(code string, userID int64) (Heat, error) {
+	heat, err := r.RetrieveHeat(code)
+	if err != nil {
+		return Heat{}, err
+	}
+	if heat.Started.Valid {
+		return heat, ErrHeatStarted
+	}
+
+	command := `INSERT INTO heatparticipants(heatid, userid)
+		SELECT id, ? FROM heats WHERE id = ? AND started IS NULL
+		ON CONFLICT DO NOTHING;`
+	_, err = r.db.Exec(command, userID, heat.ID)
+	return heat, err
+}
+
+// Removes the user from the heat before it starts.
+func (r *TimerDB) LeaveHeat(heatID int64, userID int64) error {
+	command := `DELETE FROM heatparticipants
+		WHERE heatid = ? AND userid = ? AND heatid IN (SELECT id FROM heats WHERE started IS NULL);`
+	res, err := r.db.Exec(command, heatID, userID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrHeatStarted
+	}
+	return nil
+}
+
+// Starts a timer for every participant in the heat with the same start time.
+// Participants that already have a timer running keep it, and are left
+// without a leg in the heat. Returns how many timers were started.
+func (r *TimerDB) StartHeat(heatID int64, organizerID int64, at time.Time) (int, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var participants int
+	if err := tx.QueryRow(`SELECT count(*) FROM heatparticipants WHERE heatid = ?;`, heatID).Scan(&participants); err != nil {
+		return 0, err
+	}
+	if participants == 0 {
+		return 0, ErrEmptyHeat
+	}
+
+	startTime := at.UTC().UnixMilli()
+	res, err := tx.Exec(`UPDATE heats SET started = ? WHERE id = ? AND organizerid = ? AND started IS NULL;`,
+		startTime, heatID, organizerID)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, ErrHeatStarted
+	}
+
+	command := `INSERT INTO times(starttime, userid, courseid)
+		SELECT ?, heatparticipants.userid, heats.courseid FROM heatparticipants
+		INNER JOIN heats ON heats.id = heatparticipants.heatid
+		WHERE heatparticipants.heatid = ?
+		AND heatparticipants.userid NOT IN (SELECT userid FROM times WHERE endtime IS NULL)
+		RETURNING id, userid;`
+	rows, err := tx.Query(command, startTime, heatID)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	legs := map[int64]int64{}
+	for rows.Next() {
+		var timeID, userID int64
+		if err := rows.Scan(&timeID, &userID); err != nil {
+			return 0, err
+		}
+		legs[userID] = timeID
+	}
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	rows.Close()
+
+	for userID, timeID := range legs {
+		command := `UPDATE heatparticipants SET timeid = ? WHERE heatid = ? AND userid = ?;`
+		if _, err := tx.Exec(command, timeID, heatID, userID); err != nil {
+			return 0, err
+		}
+	}
+	return len(legs), tx.Commit()
+}
+
+// Get the participants in the heat, those who finished first ordered by time.
+func (r *TimerDB) RetrieveHeatResults(v Viewer, heatID int64) ([]HeatResult, error) {
+	query := `SELECT heatparticipants.userid, ` + displayName + `, ` + visibleTo + `,
+		heatparticipants.timeid IS NOT NULL, times.computedtime
+		FROM heatparticipants
+		INNER JOIN users ON users.id = heatparticipants.userid
+		LEFT JOIN times ON times.id = heatparticipants.timeid
+		WHERE heatparticipants.heatid = ?
+		ORDER BY times.computedtime IS NULL, times.computedtime, heatparticipants.rowid;`
+	rows, err := r.db.Query(query, append(v.args(), heatID)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []HeatResult
+	for rows.Next() {
+		var res HeatResult
+		var visible bool
+		if err := rows.Scan(&res.UserID, &res.Username, &visible, &res.Started, &res.ComputedTime); err != nil {
+			return results, err
+		}
+		if !visible {
+			res.Username = ""
+		}
+		results = append(results, res)
+	}
+
+	if err = rows.Err(); err != nil {
+		return results, err
+	}
+	return results, nil
+}
+
+func (r *TimerDB) queryHeats(query string, args ...any) ([]Heat, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var heats []Heat
+	for rows.Next() {
+		var h Heat
+		err := rows.Scan(&h.ID, &h.Code, &h.Name, &h.CourseID, &h.Course,
+			&h.OrganizerID, &h.Organizer, &h.Created, &h.Started)
+		if err != nil {
+			return heats, err
+		}
+		heats = append(heats, h)
+	}
+
+	if err = rows.Err(); err != nil {
+		return heats, err
+	}
+	return heats, nil
+}
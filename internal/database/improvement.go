@@ -0,0 +1,96 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Users need MinImprovedRuns runs in the period to be on the most improved
+// leaderboard. The all time leaderboard compares with the best of the first
+// BaselineRuns runs of each user.
+const (
+	MinImprovedRuns = 3
+	BaselineRuns    = 5
+)
+
+type ImprovementResponse struct {
+	Place    int
+	Username string
+	Badges   []string
+	Best     int64 // In the period
+	Baseline int64 // The best time to improve on
+}
+
+// Improvement is how much faster the best time is than the baseline, as a
+// fraction.
+func (i ImprovementResponse) Improvement() float64 {
+	return 1 - float64(i.Best)/float64(i.Baseline)
+}
+
+// Ranks the users from the period and baseline tables in a query, the most
+// improved first. Users who didn't improve are left out.
+const improvementRanking = `SELECT ROW_NUMBER () OVER (ORDER BY CAST(period.best AS REAL) / baseline.best) rownum,
+		` + displayName + `, ` + badgeList + `, period.best, baseline.best FROM period
+	INNER JOIN baseline ON baseline.userid = period.userid
+	INNER JOIN users ON users.id = period.userid
+	WHERE ` + visibleTo + `
+	AND period.runs >= ?
+	AND period.best < baseline.best
+	ORDER BY rownum;`
+
+// Get the users whose best time on the course in the period improved the most
+// on their best time in the baseline period before it.
+func (r *TimerDB) RetrieveImprovement(v Viewer, courseID int64, from time.Time, to time.Time, baselineFrom time.Time) ([]ImprovementResponse, error) {
+	query := `WITH period AS (
+			SELECT userid, min(computedtime) best, count(*) runs FROM times
+			WHERE computedtime IS NOT NULL AND courseid = ? AND starttime >= ? AND starttime < ?
+			GROUP BY userid
+		), baseline AS (
+			SELECT userid, min(computedtime) best FROM times
+			WHERE computedtime IS NOT NULL AND courseid = ? AND starttime >= ? AND starttime < ?
+			GROUP BY userid
+		)
+		` + improvementRanking
+	args := append([]any{courseID, from.UnixMilli(), to.UnixMilli(), courseID, baselineFrom.UnixMilli(), from.UnixMilli()}, v.args()...)
+	return r.queryImprovement(query, append(args, MinImprovedRuns)...)
+}
+
+// Get the users whose best time on the course improved the most on the best
+// of their first BaselineRuns runs on it.
+func (r *TimerDB) RetrieveImprovementSinceStart(v Viewer, courseID int64) ([]ImprovementResponse, error) {
+	query := `WITH numbered AS (
+			SELECT userid, computedtime, ROW_NUMBER () OVER (PARTITION BY userid ORDER BY starttime) n FROM times
+			WHERE computedtime IS NOT NULL AND courseid = ?
+		), period AS (
+			SELECT userid, min(computedtime) best, count(*) runs FROM numbered WHERE n > ? GROUP BY userid
+		), baseline AS (
+			SELECT userid, min(computedtime) best FROM numbered WHERE n <= ? GROUP BY userid
+		)
+		` + improvementRanking
+	args := append([]any{courseID, BaselineRuns, BaselineRuns}, v.args()...)
+	return r.queryImprovement(query, append(args, MinImprovedRuns)...)
+}
+
+func (r *TimerDB) queryImprovement(query string, args ...any) ([]ImprovementResponse, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var improved []ImprovementResponse
+	for rows.Next() {
+		var i ImprovementResponse
+		var badges sql.NullString
+		if err := rows.Scan(&i.Place, &i.Username, &badges, &i.Best, &i.Baseline); err != nil {
+			return improved, err
+		}
+		i.Badges = splitBadges(badges)
+		improved = append(improved, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return improved, err
+	}
+	return improved, nil
+}
@@ -0,0 +1,142 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidInvite = errors.New("invite code is unknown, expired or already used")
+)
+
+type Invite struct {
+	ID        int64
+	Code      string
+	CreatedBy string
+	Created   int64
+	Expires   int64
+	UsedBy    sql.NullString
+	Used      sql.NullInt64
+}
+
+// Codes are typed in by hand, so they are matched without regard to case and
+// surrounding spaces.
+func inviteCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func (r *TimerDB) CreateInvite(code string, createdBy int, expires time.Time) error {
+	command := `INSERT INTO invites(code, createdby, created, expires) values(?, ?, ?, ?)`
+	_, err := r.db.Exec(command, inviteCode(code), createdBy, time.Now().UTC().UnixMilli(), expires.UnixMilli())
+	return err
+}
+
+// Get all invites, newest first, with the usernames of who created and used them.
+func (r *TimerDB) RetrieveInvites() ([]Invite, error) {
+	query := `SELECT invites.id, code, creator.username, created, expires, used_by.username, used FROM invites
+		INNER JOIN users creator ON creator.id = invites.createdby
+		LEFT JOIN users used_by ON used_by.id = invites.usedby
+		ORDER BY created DESC;`
+	rows, err := r.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invites []Invite
+	for rows.Next() {
+		var i Invite
+		if err := rows.Scan(&i.ID, &i.Code, &i.CreatedBy, &i.Created, &i.Expires, &i.UsedBy, &i.Used); err != nil {
+			return invites, err
+		}
+		invites = append(invites, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return invites, err
+	}
+	return invites, nil
+}
+
+func claimInvite(db execer, code string) error {
+	now := time.Now().UTC().UnixMilli()
+	command := `UPDATE invites SET used = ?
+		WHERE code = ? AND used IS NULL AND expires > ?;`
+	res, err := db.Exec(command, now, inviteCode(code), now)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrInvalidInvite
+	}
+	return nil
+}
+
+func (r *TimerDB) RetrieveUsersAwaitingApproval() ([]User, error) {
+	query := `SELECT id, username, email FROM users WHERE state = ? ORDER BY id;`
+	rows, err := r.db.Query(query, AwaitingApproval)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.ID, &u.Username, &u.Email); err != nil {
+			return users, err
+		}
+		users = append(users, u)
+	}
+
+	if err = rows.Err(); err != nil {
+		return users, err
+	}
+	return users, nil
+}
+
+// Lets a user that is awaiting approval log in. Returns the approved user.
+func (r *TimerDB) ApproveUser(id int64) (*User, error) {
+	command := `UPDATE users SET state = ? WHERE id = ? AND state = ?
+		RETURNING id, username, email;`
+	row := r.db.QueryRow(command, Confirmed, id, AwaitingApproval)
+
+	user := User{}
+	if err := row.Scan(&user.ID, &user.Username, &user.Email); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Removes a user that is awaiting approval. Their invite, if any, is not given back.
+func (r *TimerDB) RejectUser(id int64) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE invites SET usedby = NULL WHERE usedby = ?`, id); err != nil {
+		return err
+	}
+
+	res, err := tx.Exec(`DELETE FROM users WHERE id = ? AND state = ?`, id, AwaitingApproval)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,130 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrAwaitingApproval  = errors.New("user is awaiting approval")
+	ErrOIDCAlreadyLinked = errors.New("user is already linked to another single sign-on account")
+)
+
+// Logs in the user linked to the subject at the issuer. Returns sql.ErrNoRows
+// if no user is linked yet.
+func (r *TimerDB) LoginWithOIDC(issuer string, subject string) (*User, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	command := `SELECT id, username, email, COALESCE(state, 0) FROM users
+		WHERE oidcissuer = ? AND oidcsubject = ?;`
+	row := tx.QueryRow(command, issuer, subject)
+
+	user := User{}
+	var state int
+	if err := row.Scan(&user.ID, &user.Username, &user.Email, &state); err != nil {
+		return nil, err
+	}
+
+	return finishOIDCLogin(tx, &user, state)
+}
+
+// Links the user with the email to the subject at the issuer, and logs it in.
+// The provider has verified the email, so a user still waiting for its one-time
+// code is moved to state. Returns sql.ErrNoRows if no user has the email.
+func (r *TimerDB) LinkOIDC(email string, issuer string, subject string, state int) (*User, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	command := `SELECT id, username, email, COALESCE(state, 0), oidcsubject FROM users
+		WHERE lower(email) = lower(?);`
+	row := tx.QueryRow(command, email)
+
+	user := User{}
+	var currentState int
+	var linkedSubject sql.NullString
+	if err := row.Scan(&user.ID, &user.Username, &user.Email, &currentState, &linkedSubject); err != nil {
+		return nil, err
+	}
+	if linkedSubject.Valid {
+		return nil, ErrOIDCAlreadyLinked
+	}
+	if currentState != Created {
+		state = currentState
+	}
+
+	command = `UPDATE users SET oidcissuer = ?, oidcsubject = ?, state = ?,
+		onetimecode = NULL, onetimecodesent = NULL
+		WHERE id = ?;`
+	if _, err := tx.Exec(command, issuer, subject, state, user.ID); err != nil {
+		return nil, err
+	}
+
+	return finishOIDCLogin(tx, &user, state)
+}
+
+// Creates a user linked to the subject at the issuer, and logs it in. The user
+// has no password, but can set one with the forgotten password form. A number is
+// added to the username if it is taken.
+func (r *TimerDB) CreateOIDCUser(user User, issuer string, subject string, state int) (*User, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	user.Username, err = availableUsername(tx, user.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	command := `INSERT INTO users(username, email, password, state, oidcissuer, oidcsubject)
+		values(?, ?, '', ?, ?, ?)
+		RETURNING id;`
+	row := tx.QueryRow(command, user.Username, user.Email, state, issuer, subject)
+	if err := row.Scan(&user.ID); err != nil {
+		return nil, err
+	}
+
+	return finishOIDCLogin(tx, &user, state)
+}
+
+// Gives the user a new authcode and commits, unless an admin has yet to approve
+// the user.
+func finishOIDCLogin(tx *sql.Tx, user *User, state int) (*User, error) {
+	if state == AwaitingApproval {
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+		return nil, ErrAwaitingApproval
+	}
+
+	authcode, err := newAuthcode(tx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	user.Authcode.String = authcode
+
+	return user, tx.Commit()
+}
+
+func availableUsername(tx *sql.Tx, username string) (string, error) {
+	candidate := username
+	for i := 2; ; i++ {
+		inUse, err := nameInUse(tx, candidate, 0)
+		if err != nil {
+			return "", err
+		}
+		if !inUse {
+			return candidate, nil
+		}
+		candidate = fmt.Sprintf("%s%d", username, i)
+	}
+}
@@ -0,0 +1,132 @@
+package database
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrInvalidOneTimeCode = errors.New("invalid one time code")
+	ErrOneTimeCodeExpired = errors.New("one time code has expired")
+	ErrOneTimeCodeLocked  = errors.New("too many attempts with one time code")
+	ErrResendTooSoon      = errors.New("a one time code was sent too recently")
+)
+
+// OneTimeCodePolicy decides how long one time codes are valid, how many guesses a user
+// gets, and how often a new code can be sent.
+type OneTimeCodePolicy struct {
+	TTL            time.Duration
+	MaxAttempts    int
+	ResendCooldown time.Duration
+}
+
+var DefaultOneTimeCodePolicy = OneTimeCodePolicy{
+	TTL:            15 * time.Minute,
+	MaxAttempts:    5,
+	ResendCooldown: time.Minute,
+}
+
+// Creates a random six digit code, and the bcrypt hash that is stored in the database.
+func newOneTimeCode() (string, string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", "", err
+	}
+	code := fmt.Sprintf("%06d", n.Int64())
+
+	hashed, err := bcrypt.GenerateFromPassword([]byte(code), 10)
+	if err != nil {
+		return "", "", err
+	}
+	return code, string(hashed), nil
+}
+
+// Stores a new code for the user and resets the attempt counter. Returns the plain code to send.
+func (r *TimerDB) setOneTimeCode(db execer, userID int64, state int) (string, error) {
+	code, hashed, err := newOneTimeCode()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	command := `UPDATE users SET
+		onetimecode = ?,
+		onetimecodeexpires = ?,
+		onetimecodeattempts = 0,
+		onetimecodesent = ?,
+		state = ?
+		WHERE id = ?;`
+	_, err = db.Exec(command, hashed, now.Add(r.Codes.TTL).UnixMilli(), now.UnixMilli(), state, userID)
+	if err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// Checks a code against the stored hash. Every guess is counted before the code is
+// checked, in the same statement that checks the limit, so guesses made at the same time
+// can not get past MaxAttempts. Once it is reached the code is locked until a new one is sent.
+func (r *TimerDB) verifyOneTimeCode(userID int64, code string) error {
+	command := `UPDATE users SET onetimecodeattempts = onetimecodeattempts + 1
+		WHERE id = ? AND onetimecodeattempts < ?
+		RETURNING onetimecode, onetimecodeexpires, onetimecodeattempts;`
+	var hashed sql.NullString
+	var expires sql.NullInt64
+	var attempts int
+	err := r.db.QueryRow(command, userID, r.Codes.MaxAttempts).Scan(&hashed, &expires, &attempts)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrOneTimeCodeLocked
+	}
+	if err != nil {
+		return err
+	}
+
+	if !hashed.Valid {
+		return ErrInvalidOneTimeCode
+	}
+	if !expires.Valid || time.Now().UTC().UnixMilli() >= expires.Int64 {
+		return ErrOneTimeCodeExpired
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(hashed.String), []byte(code)); err != nil {
+		if attempts >= r.Codes.MaxAttempts {
+			return ErrOneTimeCodeLocked
+		}
+		return ErrInvalidOneTimeCode
+	}
+	return nil
+}
+
+func (r *TimerDB) canResendOneTimeCode(sent sql.NullInt64) bool {
+	if !sent.Valid {
+		return true
+	}
+	return time.Now().UTC().UnixMilli()-sent.Int64 >= r.Codes.ResendCooldown.Milliseconds()
+}
+
+// Sends a new code to a user that is registering or resetting their password.
+// Returns the code along with the state of the user, so the right email can be sent.
+func (r *TimerDB) ResendOneTimeCode(username string, email string) (string, int, error) {
+	command := `SELECT id, state, onetimecodesent FROM users
+		WHERE email = ? AND username = ? AND state IN (?, ?);`
+	row := r.db.QueryRow(command, email, username, Created, ResettingPasswrod)
+
+	var id int64
+	var state int
+	var sent sql.NullInt64
+	if err := row.Scan(&id, &state, &sent); err != nil {
+		return "", -1, err
+	}
+
+	if !r.canResendOneTimeCode(sent) {
+		return "", state, ErrResendTooSoon
+	}
+
+	code, err := r.setOneTimeCode(r.db, id, state)
+	return code, state, err
+}
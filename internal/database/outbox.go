@@ -0,0 +1,179 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type EmailState int
+
+const (
+	EmailQueued = 0
+	EmailSent   = 1
+	EmailFailed = 2
+)
+
+var (
+	ErrEmailNotFailed = errors.New("email is not in a failed state")
+	ErrEmailExpired   = errors.New("email has expired and its content is cleared")
+)
+
+func (r *TimerDB) EnqueueEmail(e OutboxEmail) (int64, error) {
+	now := time.Now().UTC().UnixMilli()
+	command := `INSERT INTO emailoutbox(recipients, subject, body, contenttype, state, attempts, nextattempt, created)
+		values(?, ?, ?, ?, ?, 0, ?, ?)
+		RETURNING id;`
+	row := r.db.QueryRow(command, e.Recipients, e.Subject, e.Body, e.ContentType, EmailQueued, now, now)
+
+	var id int64
+	if err := row.Scan(&id); err != nil {
+		return -1, err
+	}
+	return id, nil
+}
+
+// Get queued emails that are due for a delivery attempt, oldest first.
+func (r *TimerDB) RetrieveDueEmails(now time.Time, limit int) ([]OutboxEmail, error) {
+	query := `SELECT id, recipients, subject, body, contenttype, state, attempts, nextattempt, lasterror, created, sent
+		FROM emailoutbox
+		WHERE state = ? AND nextattempt <= ?
+		ORDER BY nextattempt ASC
+		LIMIT ?;`
+	return r.queryEmails(query, EmailQueued, now.UnixMilli(), limit)
+}
+
+func (r *TimerDB) RetrieveEmailsByState(state int) ([]OutboxEmail, error) {
+	query := `SELECT id, recipients, subject, body, contenttype, state, attempts, nextattempt, lasterror, created, sent
+		FROM emailoutbox
+		WHERE state = ?
+		ORDER BY created DESC;`
+	return r.queryEmails(query, state)
+}
+
+// Marks the email as sent. The body is cleared as it may contain one time codes.
+func (r *TimerDB) RecordEmailDelivered(id int64, at time.Time) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`INSERT INTO emailattempts(emailid, attempted) values(?, ?)`, id, at.UnixMilli())
+	if err != nil {
+		return err
+	}
+
+	command := `UPDATE emailoutbox SET
+		state = ?,
+		attempts = attempts + 1,
+		body = '',
+		lasterror = NULL,
+		sent = ?
+		WHERE id = ?;`
+	_, err = tx.Exec(command, EmailSent, at.UnixMilli(), id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Records a failed delivery attempt. The email is retried at next, unless giveUp is set,
+// in which case it is marked as failed and left for an admin to resend.
+func (r *TimerDB) RecordEmailFailure(id int64, at time.Time, deliveryErr string, next time.Time, giveUp bool) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`INSERT INTO emailattempts(emailid, attempted, error) values(?, ?, ?)`, id, at.UnixMilli(), deliveryErr)
+	if err != nil {
+		return err
+	}
+
+	state := EmailQueued
+	if giveUp {
+		state = EmailFailed
+	}
+
+	command := `UPDATE emailoutbox SET
+		state = ?,
+		attempts = attempts + 1,
+		lasterror = ?,
+		nextattempt = ?
+		WHERE id = ?;`
+	_, err = tx.Exec(command, state, deliveryErr, next.UnixMilli(), id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Puts a failed email back in the queue with a fresh set of attempts. Returns
+// ErrEmailExpired when the content of the email is already cleared.
+func (r *TimerDB) RequeueEmail(id int64) error {
+	command := `UPDATE emailoutbox SET
+		state = ?,
+		attempts = 0,
+		nextattempt = ?
+		WHERE id = ? AND state = ? AND body <> '';`
+	res, err := r.db.Exec(command, EmailQueued, time.Now().UTC().UnixMilli(), id, EmailFailed)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected > 0 {
+		return nil
+	}
+
+	var cleared bool
+	err = r.db.QueryRow(`SELECT body = '' FROM emailoutbox WHERE id = ? AND state = ?;`, id, EmailFailed).Scan(&cleared)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrEmailNotFailed
+	}
+	if err != nil {
+		return err
+	}
+	return ErrEmailExpired
+}
+
+// Clears the body of the emails created before the given time, as it may contain
+// one time codes. Queued emails that are still not delivered are marked as failed.
+func (r *TimerDB) ExpireEmails(before time.Time) error {
+	command := `UPDATE emailoutbox SET
+		state = ?,
+		body = '',
+		lasterror = CASE WHEN state = ? THEN 'expired before it could be delivered' ELSE lasterror END
+		WHERE state IN (?, ?) AND created < ? AND body <> '';`
+	_, err := r.db.Exec(command, EmailFailed, EmailQueued, EmailQueued, EmailFailed, before.UnixMilli())
+	return err
+}
+
+func (r *TimerDB) queryEmails(query string, args ...any) ([]OutboxEmail, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var emails []OutboxEmail
+	for rows.Next() {
+		var e OutboxEmail
+		err := rows.Scan(&e.ID, &e.Recipients, &e.Subject, &e.Body, &e.ContentType, &e.State,
+			&e.Attempts, &e.NextAttempt, &e.LastError, &e.Created, &e.Sent)
+		if err != nil {
+			return emails, err
+		}
+		emails = append(emails, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return emails, err
+	}
+	return emails, nil
+}
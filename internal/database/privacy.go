@@ -0,0 +1,73 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+var (
+	ErrAliasTaken    = errors.New("alias is used by another user")
+	ErrAliasRequired = errors.New("alias is required to show times under an alias")
+)
+
+// Privacy decides who can see a user's times on the leaderboards.
+type Privacy int
+
+const (
+	Public      Privacy = 0 // Shown with username to everyone
+	UseAlias    Privacy = 1 // Shown to everyone, but under the alias
+	MembersOnly Privacy = 2 // Only shown to logged in users
+	Hidden      Privacy = 3 // Only shown to the user
+)
+
+// Viewer is who is looking at a leaderboard. UserID is 0 when nobody is logged in.
+type Viewer struct {
+	UserID int64
+}
+
+// Every leaderboard query uses these, so it respects the privacy setting of each
+// user. displayName takes the viewer's user id as argument, and visibleTo takes
+// whether the viewer is logged in and the viewer's user id. Users always see
+// their own times with their own username.
+const (
+	displayName = `CASE WHEN users.privacy = 1 AND users.id != ? THEN users.alias ELSE users.username END`
+	visibleTo   = `(users.privacy IN (0, 1) OR (users.privacy = 2 AND ?) OR users.id = ?)`
+)
+
+// Arguments for a query that uses displayName and then visibleTo.
+func (v Viewer) args() []any {
+	return append([]any{v.UserID}, v.visibleArgs()...)
+}
+
+// Arguments for a query that only uses visibleTo.
+func (v Viewer) visibleArgs() []any {
+	loggedIn := 0
+	if v.UserID != 0 {
+		loggedIn = 1
+	}
+	return []any{loggedIn, v.UserID}
+}
+
+func (r *TimerDB) UpdatePrivacy(userID int64, privacy Privacy, alias string) error {
+	alias = strings.TrimSpace(alias)
+	if privacy == UseAlias && alias == "" {
+		return ErrAliasRequired
+	}
+
+	if alias != "" {
+		// The alias can't be someone else's username or alias, or it could be used
+		// to pass as them.
+		inUse, err := nameInUse(r.db, alias, userID)
+		if err != nil {
+			return err
+		}
+		if inUse {
+			return ErrAliasTaken
+		}
+	}
+
+	command := `UPDATE users SET privacy = ?, alias = ? WHERE id = ?;`
+	_, err := r.db.Exec(command, privacy, sql.NullString{String: alias, Valid: alias != ""}, userID)
+	return err
+}
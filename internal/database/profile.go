@@ -0,0 +1,230 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrUsernameTaken  = errors.New("username is taken")
+	ErrEmailTaken     = errors.New("email is used by another user")
+	ErrNoPendingEmail = errors.New("user has not asked to change email")
+	ErrWrongPassword  = errors.New("wrong password")
+	ErrStaleLogin     = errors.New("user must log in again")
+)
+
+func (r *TimerDB) UpdateUsername(userID int64, username string) error {
+	inUse, err := nameInUse(r.db, username, userID)
+	if err != nil {
+		return err
+	}
+	if inUse {
+		return ErrUsernameTaken
+	}
+
+	_, err = r.db.Exec(`UPDATE users SET username = ? WHERE id = ?;`, username, userID)
+	return err
+}
+
+// Stores the new email until the user has confirmed it with the code that is
+// returned, which must be sent to the new email.
+func (r *TimerDB) RequestEmailChange(userID int64, email string) (string, error) {
+	exists, id, err := r.UserExistsWithEmail(email)
+	if err != nil {
+		return "", err
+	}
+	if exists && id != userID {
+		return "", ErrEmailTaken
+	}
+
+	var sent sql.NullInt64
+	if err := r.db.QueryRow(`SELECT onetimecodesent FROM users WHERE id = ?;`, userID).Scan(&sent); err != nil {
+		return "", err
+	}
+	if !r.canResendOneTimeCode(sent) {
+		return "", ErrResendTooSoon
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE users SET pendingemail = ? WHERE id = ?;`, email, userID); err != nil {
+		return "", err
+	}
+	code, err := r.setOneTimeCode(tx, userID, Confirmed)
+	if err != nil {
+		return "", err
+	}
+	return code, tx.Commit()
+}
+
+// Changes the email to the pending one if the code is right. Returns the old
+// and the new email.
+func (r *TimerDB) ConfirmEmailChange(userID int64, code string) (string, string, error) {
+	command := `SELECT email, pendingemail FROM users
+		WHERE id = ?;`
+	row := r.db.QueryRow(command, userID)
+
+	var oldEmail string
+	var pending sql.NullString
+	if err := row.Scan(&oldEmail, &pending); err != nil {
+		return "", "", err
+	}
+	if !pending.Valid {
+		return "", "", ErrNoPendingEmail
+	}
+
+	if err := r.verifyOneTimeCode(userID, code); err != nil {
+		return "", "", err
+	}
+
+	// Someone else may have registered with the email since the code was sent
+	exists, id, err := r.UserExistsWithEmail(pending.String)
+	if err != nil {
+		return "", "", err
+	}
+	if exists && id != userID {
+		return "", "", ErrEmailTaken
+	}
+
+	command = `UPDATE users SET email = pendingemail, pendingemail = NULL,
+		onetimecode = NULL, onetimecodesent = NULL
+		WHERE id = ?;`
+	if _, err := r.db.Exec(command, userID); err != nil {
+		return "", "", err
+	}
+	return oldEmail, pending.String, nil
+}
+
+// Checks that it is the user at the keyboard, and not someone with their session.
+// The password must be right, or for users without one, who only log in with single
+// sign-on, the last login must be newer than maxAge.
+func (r *TimerDB) Reauthenticate(userID int64, password string, maxAge time.Duration) error {
+	var hashed string
+	var loggedIn sql.NullInt64
+	command := `SELECT password, authcodecreated FROM users WHERE id = ?;`
+	if err := r.db.QueryRow(command, userID).Scan(&hashed, &loggedIn); err != nil {
+		return err
+	}
+	if hashed == "" {
+		if !loggedIn.Valid || time.Now().UTC().Add(-maxAge).UnixMilli() > loggedIn.Int64 {
+			return ErrStaleLogin
+		}
+		return nil
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(hashed), []byte(password)); err != nil {
+		return ErrWrongPassword
+	}
+	return nil
+}
+
+// Changes the password if current is right. Other sessions are logged out, so
+// the new authcode is returned for the session that changed it.
+func (r *TimerDB) ChangePassword(userID int64, current string, password string) (string, error) {
+	var hashed string
+	if err := r.db.QueryRow(`SELECT password FROM users WHERE id = ?;`, userID).Scan(&hashed); err != nil {
+		return "", err
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(hashed), []byte(current)); err != nil {
+		return "", ErrWrongPassword
+	}
+
+	newHash, err := bcrypt.GenerateFromPassword([]byte(password), 10)
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE users SET password = ? WHERE id = ?;`, newHash, userID); err != nil {
+		return "", err
+	}
+	authcode, err := newAuthcode(tx, userID)
+	if err != nil {
+		return "", err
+	}
+	return authcode, tx.Commit()
+}
+
+// Removes everything that identifies the user. With keepTimes the user is
+// anonymized instead of deleted, so their times stay on the leaderboard without
+// a name.
+func (r *TimerDB) DeleteUser(userID int64, keepTimes bool) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var email string
+	if err := tx.QueryRow(`SELECT email FROM users WHERE id = ?;`, userID).Scan(&email); err != nil {
+		return err
+	}
+
+	command := `DELETE FROM emailattempts WHERE emailid IN (SELECT id FROM emailoutbox WHERE recipients = ?);`
+	if _, err := tx.Exec(command, email); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM emailoutbox WHERE recipients = ?;`, email); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM logintokens WHERE userid = ?;`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE invites SET usedby = NULL WHERE usedby = ?;`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM challenges WHERE challengerid = ? OR opponentid = ?;`, userID, userID); err != nil {
+		return err
+	}
+
+	if keepTimes {
+		command = `UPDATE users SET
+			username = ?, email = ?, password = '', state = ?, admin = 0,
+			onetimecode = NULL, onetimecodeexpires = NULL, onetimecodesent = NULL, authcode = NULL,
+			oidcissuer = NULL, oidcsubject = NULL, pendingemail = NULL, privacy = 0, alias = NULL, teamid = NULL,
+			agegroup = NULL, gender = NULL, division = NULL
+			WHERE id = ?;`
+		_, err = tx.Exec(command,
+			fmt.Sprintf("Slettet bruker %d", userID),
+			fmt.Sprintf("slettet-%d@slettet.invalid", userID),
+			Deleted, userID)
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	if _, err := tx.Exec(`DELETE FROM invites WHERE createdby = ?;`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM userachievements WHERE userid = ?;`, userID); err != nil {
+		return err
+	}
+	// The heats they organized go too, the runs in them stay on the leaderboards
+	command = `DELETE FROM heatparticipants WHERE userid = ? OR heatid IN (SELECT id FROM heats WHERE organizerid = ?);`
+	if _, err := tx.Exec(command, userID, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM heats WHERE organizerid = ?;`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM times WHERE userid = ?;`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM users WHERE id = ?;`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -5,6 +5,7 @@ import "database/sql"
 type Timer struct {
 	ID           int64
 	UserID       int64
+	CourseID     int64
 	StartTime    int64
 	EndTime      int64
 	ComputedTime sql.NullInt64
@@ -15,6 +16,26 @@ type User struct {
 	Username    string
 	Email       string
 	Password    string
+	HasPassword bool // Users who only log in with single sign-on have none
 	OneTimeCode sql.NullString
 	Authcode    sql.NullString
+	Privacy     Privacy
+	Alias       sql.NullString
+	TeamID      sql.NullInt64
+	AgeGroup    sql.NullString
+	Gender      sql.NullString
+	Division    sql.NullString
+}
+type OutboxEmail struct {
+	ID          int64
+	Recipients  string
+	Subject     string
+	Body        string
+	ContentType string
+	State       int
+	Attempts    int
+	NextAttempt int64
+	LastError   sql.NullString
+	Created     int64
+	Sent        sql.NullInt64
 }
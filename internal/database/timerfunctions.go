@@ -1,7 +1,8 @@
 package database
 
 import (
-	"log"
+	"database/sql"
+	"log/slog"
 	"time"
 )
 
@@ -9,15 +10,16 @@ type TimesCountRespose struct {
 	Place    int
 	Count    int
 	Username string
+	Badges   []string
 }
 
-func (r *TimerDB) RetrieveTimesCount() ([]TimesCountRespose, error) {
-	query := `SELECT ROW_NUMBER () OVER (ORDER BY Count(t.id) DESC), Count(t.id), users.username FROM times t
- INNER JOIN  users on users.id = t.userid GROUP BY userid;`
-	rows, err := r.db.Query(query)
-	log.Print("Queried database")
+func (r *TimerDB) RetrieveTimesCount(v Viewer) ([]TimesCountRespose, error) {
+	query := `SELECT ROW_NUMBER () OVER (ORDER BY Count(t.id) DESC), Count(t.id), ` + displayName + `, ` + badgeList + ` FROM times t
+ INNER JOIN  users on users.id = t.userid WHERE ` + visibleTo + ` GROUP BY userid;`
+	rows, err := r.db.Query(query, v.args()...)
+	slog.Debug("Queried database")
 	if err != nil {
-		log.Printf("database query failed %s", err)
+		slog.Error("database query failed", "err", err)
 
 		return nil, err
 	}
@@ -26,9 +28,11 @@ func (r *TimerDB) RetrieveTimesCount() ([]TimesCountRespose, error) {
 
 	for rows.Next() {
 		var tim TimesCountRespose
-		if err := rows.Scan(&tim.Place, &tim.Count, &tim.Username); err != nil {
+		var badges sql.NullString
+		if err := rows.Scan(&tim.Place, &tim.Count, &tim.Username, &badges); err != nil {
 			return times, err
 		}
+		tim.Badges = splitBadges(badges)
 
 		times = append(times, tim)
 	}
@@ -39,18 +43,19 @@ func (r *TimerDB) RetrieveTimesCount() ([]TimesCountRespose, error) {
 
 	return times, nil
 }
-func (r *TimerDB) RetrieveMostTimesByDate(from time.Time, to time.Time) ([]TimesCountRespose, error) {
-	query := `SELECT ROW_NUMBER () OVER (ORDER BY Count(t.id) DESC), Count(t.id), users.username FROM times t
+func (r *TimerDB) RetrieveMostTimesByDate(v Viewer, from time.Time, to time.Time) ([]TimesCountRespose, error) {
+	query := `SELECT ROW_NUMBER () OVER (ORDER BY Count(t.id) DESC), Count(t.id), ` + displayName + `, ` + badgeList + ` FROM times t
  				INNER JOIN  users 
 				ON users.id = t.userid
-				WHERE t.computedtime IS NOT NULL
+				WHERE ` + visibleTo + `
+				AND t.computedtime IS NOT NULL
 				AND t.starttime >= ?
 				AND t.startTime < ?
 				GROUP BY userid;`
-	rows, err := r.db.Query(query, from.UnixMilli(), to.UnixMilli())
-	log.Print("Queried database")
+	rows, err := r.db.Query(query, append(v.args(), from.UnixMilli(), to.UnixMilli())...)
+	slog.Debug("Queried database")
 	if err != nil {
-		log.Printf("database query failed %s", err)
+		slog.Error("database query failed", "err", err)
 
 		return nil, err
 	}
@@ -59,9 +64,11 @@ func (r *TimerDB) RetrieveMostTimesByDate(from time.Time, to time.Time) ([]Times
 
 	for rows.Next() {
 		var tim TimesCountRespose
-		if err := rows.Scan(&tim.Place, &tim.Count, &tim.Username); err != nil {
+		var badges sql.NullString
+		if err := rows.Scan(&tim.Place, &tim.Count, &tim.Username, &badges); err != nil {
 			return times, err
 		}
+		tim.Badges = splitBadges(badges)
 
 		times = append(times, tim)
 	}
@@ -74,17 +81,31 @@ func (r *TimerDB) RetrieveMostTimesByDate(from time.Time, to time.Time) ([]Times
 }
 
 // Get fastest times by times. Time provided should be an UTC date.
-func (r *TimerDB) RetrieveFastestTimeByTime(from time.Time, to time.Time) ([]RetrieveTimesResponse, error) {
+func (r *TimerDB) RetrieveFastestTimeByTime(v Viewer, courseID int64, from time.Time, to time.Time) ([]RetrieveTimesResponse, error) {
+	return r.RetrieveFastestTimeInCategory(v, courseID, from, to, Category{})
+}
+
+// Get fastest times by times of the users in the category, placed against
+// each other. A courseID of 0 compares the runs on every course.
+func (r *TimerDB) RetrieveFastestTimeInCategory(v Viewer, courseID int64, from time.Time, to time.Time, c Category) ([]RetrieveTimesResponse, error) {
+	inCategory, categoryArgs, err := c.where()
+	if err != nil {
+		return nil, err
+	}
 
-	query := `SELECT ROW_NUMBER () OVER (ORDER BY times.computedtime ASC) rownum, min(times.computedtime), username FROM times 
+	query := `SELECT ROW_NUMBER () OVER (ORDER BY times.computedtime ASC) rownum, min(times.computedtime), users.id, ` + displayName + `, ` + badgeList + ` FROM times 
 		INNER JOIN users on users.id = userid
-		WHERE times.computedtime IS NOT NULL
+		WHERE ` + visibleTo + `
+		AND ` + inCategory + `
+		AND (? = 0 OR times.courseid = ?)
+		AND times.computedtime IS NOT NULL
 		AND times.starttime >= ?
 		AND times.startTime < ?
 		GROUP BY userid;`
-	rows, err := r.db.Query(query, from.UnixMilli(), to.UnixMilli())
+	args := append(append(v.args(), categoryArgs...), courseID, courseID, from.UnixMilli(), to.UnixMilli())
+	rows, err := r.db.Query(query, args...)
 	if err != nil {
-		log.Printf("database query failed %s", err)
+		slog.Error("database query failed", "err", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -93,9 +114,11 @@ func (r *TimerDB) RetrieveFastestTimeByTime(from time.Time, to time.Time) ([]Ret
 
 	for rows.Next() {
 		var tim RetrieveTimesResponse
-		if err := rows.Scan(&tim.Place, &tim.ComputedTime, &tim.Username); err != nil {
+		var badges sql.NullString
+		if err := rows.Scan(&tim.Place, &tim.ComputedTime, &tim.UserID, &tim.Username, &badges); err != nil {
 			return times, err
 		}
+		tim.Badges = splitBadges(badges)
 
 		times = append(times, tim)
 	}
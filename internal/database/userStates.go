@@ -6,4 +6,6 @@ const (
 	Created           = 0
 	Confirmed         = 1
 	ResettingPasswrod = 2
+	AwaitingApproval  = 3
+	Deleted           = 4 // Anonymized, kept so the user's times stay on the leaderboard
 )
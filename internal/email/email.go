@@ -18,6 +18,7 @@ type EmailClient struct {
 	HostAddr   string
 	SenderAddr string
 	Password   string
+	Outbox     *Outbox // When set, emails are queued instead of sent directly
 }
 
 func (c EmailClient) SendEmail(e *EmailMessage) error {
@@ -29,6 +30,14 @@ func (c EmailClient) SendEmail(e *EmailMessage) error {
 	return err
 }
 
+// deliver queues the message in the outbox if there is one, or sends it right away.
+func (c EmailClient) deliver(e *EmailMessage) error {
+	if c.Outbox != nil {
+		return c.Outbox.Enqueue(e)
+	}
+	return c.SendEmail(e)
+}
+
 func (e *EmailMessage) BuildBody() []byte {
 	from := fmt.Sprintf("From: %s\r\n", e.from)
 	to := "To: "
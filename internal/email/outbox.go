@@ -0,0 +1,108 @@
+package email
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/KimBrusevold/webTimer/internal/database"
+	"github.com/KimBrusevold/webTimer/internal/metrics"
+)
+
+const maxBackoff = 6 * time.Hour
+
+// Outbox stores outgoing emails in the database and delivers them in the background,
+// so a failing mail server does not lose messages or block requests.
+type Outbox struct {
+	DB          *database.TimerDB
+	Client      *EmailClient
+	Interval    time.Duration // How often the queue is checked for due emails
+	Backoff     time.Duration // Wait before the first retry. Doubles for each failed attempt
+	MaxAttempts int           // Attempts before the email is marked as failed
+	Expiry      time.Duration // How long an email is kept. After this its body is cleared and it can not be resent
+	Metrics     *metrics.Metrics
+}
+
+func (o *Outbox) Enqueue(e *EmailMessage) error {
+	_, err := o.DB.EnqueueEmail(database.OutboxEmail{
+		Recipients:  strings.Join(e.to, ","),
+		Subject:     e.subject,
+		Body:        e.body,
+		ContentType: e.contentType,
+	})
+	return err
+}
+
+// Run delivers due emails every Interval until ctx is cancelled.
+func (o *Outbox) Run(ctx context.Context) {
+	ticker := time.NewTicker(o.Interval)
+	defer ticker.Stop()
+
+	for {
+		o.DeliverDue()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (o *Outbox) DeliverDue() {
+	if err := o.DB.ExpireEmails(time.Now().UTC().Add(-o.Expiry)); err != nil {
+		slog.Error("Could not clear expired emails", "err", err)
+	}
+
+	emails, err := o.DB.RetrieveDueEmails(time.Now().UTC(), 20)
+	if err != nil {
+		slog.Error("Could not retrieve queued emails", "err", err)
+		return
+	}
+
+	for _, e := range emails {
+		m := NewEmailMessage(o.Client.SenderAddr).AddRecipients(strings.Split(e.Recipients, ",")...).SetSubject(e.Subject)
+		m.body = e.Body
+		m.contentType = e.ContentType
+
+		sendErr := o.Client.SendEmail(m)
+		now := time.Now().UTC()
+		if sendErr == nil {
+			if err := o.DB.RecordEmailDelivered(e.ID, now); err != nil {
+				slog.Error("Email was sent, but could not be marked as delivered", "emailId", e.ID, "err", err)
+			}
+			continue
+		}
+
+		o.Metrics.EmailFailed()
+		attempts := e.Attempts + 1
+		giveUp := attempts >= o.MaxAttempts
+		if giveUp {
+			slog.Error("Giving up sending email", "emailId", e.ID, "attempts", attempts, "err", sendErr)
+		} else {
+			slog.Warn("Sending email failed", "emailId", e.ID, "attempts", attempts, "err", sendErr)
+		}
+
+		err := o.DB.RecordEmailFailure(e.ID, now, sendErr.Error(), now.Add(o.backoff(attempts)), giveUp)
+		if err != nil {
+			slog.Error("Could not record failed delivery of email", "emailId", e.ID, "err", err)
+		}
+	}
+}
+
+// Resend puts a failed email back in the queue, unless it has expired.
+func (o *Outbox) Resend(id int64) error {
+	return o.DB.RequeueEmail(id)
+}
+
+func (o *Outbox) backoff(attempts int) time.Duration {
+	d := o.Backoff
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= maxBackoff {
+			return maxBackoff
+		}
+	}
+	return d
+}
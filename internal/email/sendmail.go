@@ -3,13 +3,86 @@ package email
 func (ec *EmailClient) SendAuthEmail(emailAddr string, oneTimeCode string) error {
 	fromEmailAddress := ec.SenderAddr
 	m := NewEmailMessage(fromEmailAddress).AddRecipients(emailAddr).SetSubject("Klar for trappeløp?").AddStringContent("Du er nesten klar. Bruk denne koden for å bekrefte din epost: \n" + oneTimeCode)
-	err := ec.SendEmail(m)
+	err := ec.deliver(m)
 	return err
 }
 
 func (ec *EmailClient) SendPasswordCode(code string, toEmail string) error {
 	fromEmailAddress := ec.SenderAddr
 	m := NewEmailMessage(fromEmailAddress).AddRecipients(toEmail).SetSubject("Tilbakestill ditt passord").AddStringContent("Bruk denne koden for å tilbakestille ditt passord: \n" + code)
-	err := ec.SendEmail(m)
+	err := ec.deliver(m)
+	return err
+}
+
+func (ec *EmailClient) SendLoginLink(link string, toEmail string) error {
+	fromEmailAddress := ec.SenderAddr
+	m := NewEmailMessage(fromEmailAddress).AddRecipients(toEmail).SetSubject("Logg inn på trappeløp").AddStringContent("Trykk på lenken for å logge inn. Lenken kan bare brukes én gang, og utløper snart: \n" + link)
+	err := ec.deliver(m)
+	return err
+}
+
+func (ec *EmailClient) SendApprovedEmail(toEmail string) error {
+	fromEmailAddress := ec.SenderAddr
+	m := NewEmailMessage(fromEmailAddress).AddRecipients(toEmail).SetSubject("Du er godkjent!").AddStringContent("Kontoen din er godkjent, og du kan nå logge inn og begynne å løpe.")
+	err := ec.deliver(m)
+	return err
+}
+
+func (ec *EmailClient) SendEmailChangeCode(code string, toEmail string) error {
+	fromEmailAddress := ec.SenderAddr
+	m := NewEmailMessage(fromEmailAddress).AddRecipients(toEmail).SetSubject("Bekreft ny epost").AddStringContent("Bruk denne koden for å bekrefte din nye epost: \n" + code)
+	err := ec.deliver(m)
+	return err
+}
+
+// Lets the old address know, in case someone else changed it.
+func (ec *EmailClient) SendEmailChanged(oldEmail string, newEmail string) error {
+	fromEmailAddress := ec.SenderAddr
+	m := NewEmailMessage(fromEmailAddress).AddRecipients(oldEmail).SetSubject("Eposten din er endret").AddStringContent("Eposten på kontoen din for trappeløp er endret til " + newEmail + ". Ta kontakt med en administrator om det ikke var deg.")
+	err := ec.deliver(m)
+	return err
+}
+
+func (ec *EmailClient) SendGoalReached(toEmail string, goal string, result string) error {
+	fromEmailAddress := ec.SenderAddr
+	m := NewEmailMessage(fromEmailAddress).AddRecipients(toEmail).SetSubject("Vi nådde målet!").AddStringContent("Sammen har vi nådd målet \"" + goal + "\" med " + result + ". Takk til alle som løp!")
+	err := ec.deliver(m)
+	return err
+}
+
+func (ec *EmailClient) SendChallengeInvite(toEmail string, challenger string, course string, target string, deadline string) error {
+	fromEmailAddress := ec.SenderAddr
+	m := NewEmailMessage(fromEmailAddress).AddRecipients(toEmail).SetSubject(challenger + " utfordrer deg!").AddStringContent(challenger + " utfordrer deg til å slå tiden " + target + " på " + course + " innen " + deadline + ". Svar på utfordringen under Utfordringer.")
+	err := ec.deliver(m)
+	return err
+}
+
+func (ec *EmailClient) SendChallengeAnswer(toEmail string, opponent string, accepted bool) error {
+	fromEmailAddress := ec.SenderAddr
+	subject, body := "Utfordringen er godtatt", opponent+" har godtatt utfordringen din. Løpet er i gang!"
+	if !accepted {
+		subject, body = "Utfordringen er avslått", opponent+" har takket nei til utfordringen din."
+	}
+	m := NewEmailMessage(fromEmailAddress).AddRecipients(toEmail).SetSubject(subject).AddStringContent(body)
+	err := ec.deliver(m)
+	return err
+}
+
+// Lets the opponent know the time they have to beat is lower.
+func (ec *EmailClient) SendChallengeRaised(toEmail string, challenger string, course string, target string) error {
+	fromEmailAddress := ec.SenderAddr
+	m := NewEmailMessage(fromEmailAddress).AddRecipients(toEmail).SetSubject("Utfordringen ble vanskeligere").AddStringContent(challenger + " har slått sin egen tid på " + course + ". Nå må du slå " + target + ".")
+	err := ec.deliver(m)
+	return err
+}
+
+func (ec *EmailClient) SendChallengeResult(toEmail string, other string, course string, won bool) error {
+	fromEmailAddress := ec.SenderAddr
+	subject, body := "Du vant utfordringen!", "Du vant utfordringen mot "+other+" på "+course+"."
+	if !won {
+		subject, body = "Du tapte utfordringen", other+" vant utfordringen på "+course+". Bedre lykke neste gang!"
+	}
+	m := NewEmailMessage(fromEmailAddress).AddRecipients(toEmail).SetSubject(subject).AddStringContent(body)
+	err := ec.deliver(m)
 	return err
 }
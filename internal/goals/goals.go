@@ -0,0 +1,127 @@
+// Package goals tracks the goals everyone reaches together, and announces them
+// by email when they are reached.
+package goals
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"time"
+
+	"github.com/KimBrusevold/webTimer/internal/database"
+	"github.com/KimBrusevold/webTimer/internal/email"
+)
+
+type Progress struct {
+	database.Goal
+	Done    float64
+	Percent int // Up to 100
+}
+
+// Complete is true when the goal is reached, also before it has been announced.
+func (p Progress) Complete() bool {
+	return p.Done >= p.Target
+}
+
+// Unit is what the goal counts, in Norwegian.
+func Unit(kind database.GoalKind) string {
+	if kind == database.GoalMeters {
+		return "høydemeter"
+	}
+	return "løp"
+}
+
+type Tracker struct {
+	DB          *database.TimerDB
+	EmailClient *email.EmailClient
+}
+
+// Of gives the progress of the goal, counting the runs finished between its
+// start and end.
+func (t Tracker) Of(g database.Goal) (Progress, error) {
+	climb, err := t.DB.RetrieveClimb(time.UnixMilli(g.Starts), time.UnixMilli(g.Ends))
+	if err != nil {
+		return Progress{}, err
+	}
+
+	p := Progress{Goal: g, Done: float64(climb.Runs)}
+	if g.Kind == database.GoalMeters {
+		p.Done = climb.Meters
+	}
+	p.Percent = 100
+	if g.Target > 0 && p.Done < g.Target {
+		p.Percent = int(p.Done / g.Target * 100)
+	}
+	return p, nil
+}
+
+// All gives the progress of every goal, the latest first.
+func (t Tracker) All() ([]Progress, error) {
+	goals, err := t.DB.RetrieveGoals()
+	if err != nil {
+		return nil, err
+	}
+	return t.progress(goals)
+}
+
+// Active gives the progress of the goals that are running now.
+func (t Tracker) Active() ([]Progress, error) {
+	goals, err := t.DB.RetrieveActiveGoals(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return t.progress(goals)
+}
+
+// Check announces the active goals that have been reached since the last
+// check. Call it when a run is finished, or a goal is added.
+func (t Tracker) Check(ctx context.Context) error {
+	active, err := t.Active()
+	if err != nil {
+		return err
+	}
+
+	for _, p := range active {
+		if p.Reached.Valid || !p.Complete() {
+			continue
+		}
+		first, err := t.DB.MarkGoalReached(p.ID, time.Now())
+		if err != nil {
+			return err
+		}
+		if first {
+			t.announce(ctx, p)
+		}
+	}
+	return nil
+}
+
+// Emails everyone. A failed email is logged, so the rest still get theirs.
+func (t Tracker) announce(ctx context.Context, p Progress) {
+	emails, err := t.DB.ConfirmedEmails()
+	if err != nil {
+		slog.ErrorContext(ctx, "Could not get emails to announce goal", "goalId", p.ID, "err", err)
+		return
+	}
+
+	result := fmt.Sprintf("%d %s", int(math.Round(p.Done)), Unit(p.Kind))
+	for _, e := range emails {
+		if err := t.EmailClient.SendGoalReached(e, p.Name, result); err != nil {
+			slog.ErrorContext(ctx, "Could not queue goal reached email", "goalId", p.ID, "err", err)
+		}
+	}
+	slog.InfoContext(ctx, "Goal reached", "goalId", p.ID, "recipients", len(emails))
+}
+
+func (t Tracker) progress(goals []database.Goal) ([]Progress, error) {
+	var all []Progress
+	for _, g := range goals {
+		p, err := t.Of(g)
+		if err != nil {
+			return all, err
+		}
+		all = append(all, p)
+	}
+	return all, nil
+}
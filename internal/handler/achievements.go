@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/KimBrusevold/webTimer/internal/achievements"
+	"github.com/KimBrusevold/webTimer/internal/database"
+	"github.com/gin-gonic/gin"
+)
+
+type AchievementsHandler struct {
+	DB *database.TimerDB
+}
+
+// The routes should run after AuthMiddelware.Identify, like the leaderboards,
+// so the holders are shown by their privacy settings.
+func (ah AchievementsHandler) SetupRoutes(rg *gin.RouterGroup) {
+	rg.GET("", ah.showBadges)
+}
+
+func (ah AchievementsHandler) showBadges(c *gin.Context) {
+	v := viewer(c)
+	all, err := ah.DB.RetrieveAchievements(v)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not get achievements", "err", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var mine []database.Achievement
+	if v.UserID != 0 {
+		mine, err = ah.DB.UserAchievements(v.UserID)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Could not get achievements of user", "err", err)
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	c.HTML(http.StatusOK, "achievements.tmpl", gin.H{
+		"title":    "Merker",
+		"loggedIn": v.UserID != 0,
+		"badges":   achievements.Summarize(all, mine),
+	})
+}
@@ -0,0 +1,398 @@
+package handler
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/KimBrusevold/webTimer/internal/database"
+	"github.com/KimBrusevold/webTimer/internal/email"
+	"github.com/KimBrusevold/webTimer/internal/goals"
+	"github.com/KimBrusevold/webTimer/internal/middelware"
+	"github.com/KimBrusevold/webTimer/internal/model"
+	"github.com/KimBrusevold/webTimer/internal/signup"
+	"github.com/gin-gonic/gin"
+)
+
+type AdminHandler struct {
+	DB          *database.TimerDB
+	Outbox      *email.Outbox
+	EmailClient *email.EmailClient
+	Goals       goals.Tracker
+}
+
+func (ah AdminHandler) SetupRoutes(rg *gin.RouterGroup) {
+	authMW := middelware.AuthMiddelware{
+		DB: ah.DB,
+	}
+	rg.Use(authMW.Authenticate, authMW.RequireAdmin)
+	rg.GET("/epost", ah.emailOutboxPage)
+	rg.POST("/epost/:id/send-pa-nytt", ah.resendEmail)
+
+	rg.GET("/invitasjoner", ah.invitesPage)
+	rg.POST("/invitasjoner", ah.createInvite)
+
+	rg.GET("/godkjenning", ah.approvalsPage)
+	rg.POST("/godkjenning/:id/godkjenn", ah.approveUser)
+	rg.POST("/godkjenning/:id/avvis", ah.rejectUser)
+
+	rg.GET("/loyper", ah.coursesPage)
+	rg.POST("/loyper", ah.saveCourse)
+
+	rg.GET("/lag", ah.teamsPage)
+	rg.POST("/lag", ah.createTeam)
+
+	rg.GET("/mal", ah.goalsPage)
+	rg.POST("/mal", ah.createGoal)
+	rg.POST("/mal/:id/slett", ah.deleteGoal)
+}
+
+func (ah AdminHandler) emailOutboxPage(c *gin.Context) {
+	failed, err := ah.DB.RetrieveEmailsByState(database.EmailFailed)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not get failed emails from db", "err", err)
+		c.String(http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+
+	queued, err := ah.DB.RetrieveEmailsByState(database.EmailQueued)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not get queued emails from db", "err", err)
+		c.String(http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+
+	c.HTML(http.StatusOK, "email-outbox.tmpl", gin.H{
+		"title":  "Epostkø",
+		"failed": toOutboxEmailDisplay(failed),
+		"queued": toOutboxEmailDisplay(queued),
+	})
+}
+
+func (ah AdminHandler) resendEmail(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Ugyldig id")
+		return
+	}
+
+	err = ah.Outbox.Resend(id)
+	if err == database.ErrEmailNotFailed {
+		c.String(http.StatusOK, "Allerede lagt i kø")
+		return
+	}
+	if err == database.ErrEmailExpired {
+		c.String(http.StatusOK, "Utløpt")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not requeue email", "emailId", id, "err", err)
+		c.String(http.StatusInternalServerError, "Noe gikk galt")
+		return
+	}
+
+	c.String(http.StatusOK, "Lagt i kø")
+}
+
+func (ah AdminHandler) invitesPage(c *gin.Context) {
+	invites, err := ah.DB.RetrieveInvites()
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not get invites from db", "err", err)
+		c.String(http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+
+	var display []model.InviteDisplay
+	for _, i := range invites {
+		display = append(display, model.InviteDisplay{
+			Code:      i.Code,
+			CreatedBy: i.CreatedBy,
+			Created:   time.UnixMilli(i.Created).Format("02.01.2006 15:04"),
+			Expires:   time.UnixMilli(i.Expires).Format("02.01.2006 15:04"),
+			UsedBy:    i.UsedBy.String,
+			Used:      i.Used.Valid,
+		})
+	}
+
+	c.HTML(http.StatusOK, "invites.tmpl", gin.H{
+		"title":   "Invitasjoner",
+		"invites": display,
+		"csrf":    middelware.CSRFToken(c),
+	})
+}
+
+func (ah AdminHandler) createInvite(c *gin.Context) {
+	days, err := strconv.Atoi(c.PostForm("days"))
+	if err != nil || days < 1 {
+		c.String(http.StatusBadRequest, "Ugyldig antall dager")
+		return
+	}
+
+	code, err := signup.NewInviteCode()
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not create invite code", "err", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	userId := c.GetInt("userId")
+	if err := ah.DB.CreateInvite(code, userId, time.Now().UTC().AddDate(0, 0, days)); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not store invite code", "err", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.Header("Location", "/admin/invitasjoner")
+	c.Status(http.StatusSeeOther)
+}
+
+func (ah AdminHandler) approvalsPage(c *gin.Context) {
+	users, err := ah.DB.RetrieveUsersAwaitingApproval()
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not get users awaiting approval from db", "err", err)
+		c.String(http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+
+	c.HTML(http.StatusOK, "approvals.tmpl", gin.H{
+		"title": "Godkjenning",
+		"users": users,
+	})
+}
+
+func (ah AdminHandler) approveUser(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Ugyldig id")
+		return
+	}
+
+	user, err := ah.DB.ApproveUser(id)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not approve user", "userId", id, "err", err)
+		c.String(http.StatusOK, "Kunne ikke godkjenne")
+		return
+	}
+
+	if err := ah.EmailClient.SendApprovedEmail(user.Email); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Something went wrong queueing approval email", "err", err)
+	}
+	c.String(http.StatusOK, "Godkjent")
+}
+
+func (ah AdminHandler) rejectUser(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Ugyldig id")
+		return
+	}
+
+	if err := ah.DB.RejectUser(id); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not reject user", "userId", id, "err", err)
+		c.String(http.StatusOK, "Kunne ikke avvise")
+		return
+	}
+	c.String(http.StatusOK, "Avvist")
+}
+
+func (ah AdminHandler) coursesPage(c *gin.Context) {
+	courses, err := ah.DB.RetrieveCourses()
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not get courses from db", "err", err)
+		c.String(http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+
+	c.HTML(http.StatusOK, "courses.tmpl", gin.H{
+		"title":   "Løyper",
+		"courses": courses,
+		"csrf":    middelware.CSRFToken(c),
+	})
+}
+
+// Creates a course, or updates it when the form has its id.
+func (ah AdminHandler) saveCourse(c *gin.Context) {
+	id, err := strconv.ParseInt(c.DefaultPostForm("id", "0"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Ugyldig id")
+		return
+	}
+	name := strings.TrimSpace(c.PostForm("name"))
+	if name == "" {
+		c.String(http.StatusBadRequest, "Løypen må ha et navn")
+		return
+	}
+	floors, err := strconv.Atoi(c.PostForm("floors"))
+	if err != nil || floors < 0 {
+		c.String(http.StatusBadRequest, "Ugyldig antall etasjer")
+		return
+	}
+	height, err := strconv.ParseFloat(strings.Replace(c.PostForm("height"), ",", ".", 1), 64)
+	if err != nil || height < 0 {
+		c.String(http.StatusBadRequest, "Ugyldig høydeforskjell")
+		return
+	}
+
+	_, err = ah.DB.SaveCourse(database.Course{ID: id, Name: name, Floors: floors, HeightMeters: height})
+	if errors.Is(err, database.ErrCourseTaken) {
+		c.String(http.StatusBadRequest, "Det finnes alt en løype med det navnet")
+		return
+	}
+	if errors.Is(err, database.ErrUnknownCourse) {
+		c.String(http.StatusNotFound, "Fant ikke løypen")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not save course", "err", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.Header("Location", "/admin/loyper")
+	c.Status(http.StatusSeeOther)
+}
+
+func (ah AdminHandler) teamsPage(c *gin.Context) {
+	teams, err := ah.DB.RetrieveTeams()
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not get teams from db", "err", err)
+		c.String(http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+
+	c.HTML(http.StatusOK, "teams.tmpl", gin.H{
+		"title": "Lag",
+		"teams": teams,
+		"csrf":  middelware.CSRFToken(c),
+	})
+}
+
+func (ah AdminHandler) createTeam(c *gin.Context) {
+	name := strings.TrimSpace(c.PostForm("name"))
+	if name == "" {
+		c.String(http.StatusBadRequest, "Laget må ha et navn")
+		return
+	}
+
+	err := ah.DB.CreateTeam(name)
+	if errors.Is(err, database.ErrTeamTaken) {
+		c.String(http.StatusBadRequest, "Det finnes alt et lag med det navnet")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not create team", "err", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.Header("Location", "/admin/lag")
+	c.Status(http.StatusSeeOther)
+}
+
+func (ah AdminHandler) goalsPage(c *gin.Context) {
+	progress, err := ah.Goals.All()
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not get goals from db", "err", err)
+		c.String(http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+
+	c.HTML(http.StatusOK, "goals.tmpl", gin.H{
+		"title": "Mål",
+		"goals": toGoalDisplays(progress),
+		"today": time.Now().UTC().Format(time.DateOnly),
+		"csrf":  middelware.CSRFToken(c),
+	})
+}
+
+// Dates are whole days, and the goal includes the last day.
+func (ah AdminHandler) createGoal(c *gin.Context) {
+	name := strings.TrimSpace(c.PostForm("name"))
+	if name == "" {
+		c.String(http.StatusBadRequest, "Målet må ha et navn")
+		return
+	}
+	kind := database.GoalKind(c.PostForm("kind"))
+	if kind != database.GoalRuns && kind != database.GoalMeters {
+		c.String(http.StatusBadRequest, "Ukjent type mål")
+		return
+	}
+	target, err := strconv.ParseFloat(strings.Replace(c.PostForm("target"), ",", ".", 1), 64)
+	if err != nil || target <= 0 {
+		c.String(http.StatusBadRequest, "Ugyldig mål")
+		return
+	}
+	starts, err := time.Parse(time.DateOnly, c.PostForm("starts"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Ugyldig startdato")
+		return
+	}
+	ends, err := time.Parse(time.DateOnly, c.PostForm("ends"))
+	if err != nil || ends.Before(starts) {
+		c.String(http.StatusBadRequest, "Ugyldig sluttdato")
+		return
+	}
+
+	_, err = ah.DB.CreateGoal(database.Goal{
+		Name:   name,
+		Kind:   kind,
+		Target: target,
+		Starts: starts.UnixMilli(),
+		Ends:   ends.AddDate(0, 0, 1).UnixMilli(),
+	})
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not create goal", "err", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	// The goal may be reached already, when it starts in the past
+	if err := ah.Goals.Check(c.Request.Context()); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not check goals", "err", err)
+	}
+
+	c.Header("Location", "/admin/mal")
+	c.Status(http.StatusSeeOther)
+}
+
+func (ah AdminHandler) deleteGoal(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Ugyldig id")
+		return
+	}
+
+	err = ah.DB.DeleteGoal(id)
+	if errors.Is(err, database.ErrUnknownGoal) {
+		c.String(http.StatusNotFound, "Fant ikke målet")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not delete goal", "goalId", id, "err", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	// The goal is deleted with htmx, so the page is reloaded with HX-Redirect
+	c.Header("HX-Redirect", "/admin/mal")
+	c.Status(http.StatusOK)
+}
+
+func toOutboxEmailDisplay(emails []database.OutboxEmail) []model.OutboxEmailDisplay {
+	var display []model.OutboxEmailDisplay
+	for _, e := range emails {
+		display = append(display, model.OutboxEmailDisplay{
+			ID:          e.ID,
+			Recipients:  e.Recipients,
+			Subject:     e.Subject,
+			Attempts:    e.Attempts,
+			LastError:   e.LastError.String,
+			Created:     time.UnixMilli(e.Created).Format("02.01.2006 15:04"),
+			NextAttempt: time.UnixMilli(e.NextAttempt).Format("02.01.2006 15:04"),
+			Expired:     e.Body == "",
+		})
+	}
+	return display
+}
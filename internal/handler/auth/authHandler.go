@@ -3,25 +3,55 @@ package auth
 import (
 	"github.com/KimBrusevold/webTimer/internal/database"
 	"github.com/KimBrusevold/webTimer/internal/email"
+	"github.com/KimBrusevold/webTimer/internal/magiclink"
+	"github.com/KimBrusevold/webTimer/internal/metrics"
+	"github.com/KimBrusevold/webTimer/internal/middelware"
+	"github.com/KimBrusevold/webTimer/internal/oidc"
+	"github.com/KimBrusevold/webTimer/internal/password"
+	"github.com/KimBrusevold/webTimer/internal/ratelimit"
+	"github.com/KimBrusevold/webTimer/internal/signup"
 	"github.com/gin-gonic/gin"
 )
 
 type AuthHandler struct {
 	DB          *database.TimerDB
 	EmailClient *email.EmailClient
+	LoginLinks  magiclink.Signer
+	HostUrl     string
+	Cookies     middelware.CookieConfig
+	RateLimit   *middelware.RateLimitMiddelware
+	Lockout     ratelimit.Lockout
+	Signup      signup.Policy
+	Passwords   password.Policy
+	SSO         *oidc.Provider // nil when single sign-on is not configured
+	SSOName     string         // Name of the identity provider, shown on the login button
+	Metrics     *metrics.Metrics
 }
 
 func (a AuthHandler) SetupRoutes(rg *gin.RouterGroup) {
+	limited := rg.Group("", a.RateLimit.Limit)
+
 	rg.GET("/innlogging", a.loginPage)
-	rg.POST("/innlogging", a.loginUser)
+	limited.POST("/innlogging", a.loginUser)
+
+	limited.POST("/innlogging/lenke", a.sendLoginLink)
+	rg.GET("/innlogging/lenke", a.loginLinkPage)
+	limited.POST("/innlogging/lenke/bekreft", a.loginWithLink)
 
 	rg.GET("/registrer-bruker", a.registerUserPage)
-	rg.POST("/registrer-bruker", a.createUser)
+	limited.POST("/registrer-bruker", a.createUser)
+	rg.POST("/registrer-bruker/passord", a.validatePassword)
 
-	rg.POST("/engangskode", a.oneTimeCode)
+	limited.POST("/engangskode", a.oneTimeCode)
+	limited.POST("/engangskode/send-pa-nytt", a.resendOneTimeCode)
 
 	rg.GET("/nytt-passord", a.newPassword)
-	rg.POST("/nytt-passord", a.setnewPassword)
+	limited.POST("/nytt-passord", a.setnewPassword)
+
+	limited.POST("/nytt-passord/email", a.sendNewPasswordEmail)
 
-	rg.POST("/nytt-passord/email", a.sendNewPasswordEmail)
+	if a.SSO != nil {
+		rg.GET("/sso", a.startSSO)
+		rg.GET("/sso/callback", a.ssoCallback)
+	}
 }
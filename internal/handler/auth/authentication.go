@@ -2,16 +2,15 @@ package auth
 
 import (
 	"errors"
-	"log"
+	"log/slog"
 	"net/http"
 	"strings"
 
 	"github.com/KimBrusevold/webTimer/internal/database"
+	"github.com/KimBrusevold/webTimer/internal/signup"
 	"github.com/gin-gonic/gin"
 )
 
-var hostUrl string
-
 type fieldResponse struct {
 	Error        bool
 	Errormessage string
@@ -23,6 +22,8 @@ func (ah AuthHandler) registerUserPage(c *gin.Context) {
 		"email": fieldResponse{
 			Error: false,
 		},
+		"emailHint":  ah.Signup.EmailHint(),
+		"showInvite": ah.Signup.Mode != signup.Open,
 	})
 }
 
@@ -32,22 +33,93 @@ func (ah AuthHandler) oneTimeCode(c *gin.Context) {
 		return
 	}
 
-	user.OneTimeCode.String = c.PostForm("oneTimeCode")
+	user.OneTimeCode.String = strings.TrimSpace(c.PostForm("oneTimeCode"))
 	if user.OneTimeCode.String == "" {
 		c.String(http.StatusUnprocessableEntity, "Ugyldig engangskode", nil)
 		return
 	}
 	user.OneTimeCode.Valid = true
 
-	err = ah.DB.ConfirmOneTimeCode(user)
+	nextState := database.Confirmed
+	if ah.Signup.RequireApproval {
+		nextState = database.AwaitingApproval
+	}
+
+	err = ah.DB.ConfirmOneTimeCode(user, nextState)
 	if err != nil {
-		c.String(http.StatusInternalServerError, "Noe gitt galt")
+		slog.WarnContext(c.Request.Context(), "Could not confirm one time code", "err", err)
+		c.HTML(http.StatusOK, "one-time-code.tmpl", gin.H{
+			"username": user.Username,
+			"email":    user.Email,
+			"password": user.Password,
+			"error":    oneTimeCodeErrorMessage(err),
+		})
+		return
+	}
+	ah.Lockout.Succeeded(lockoutAccount(user.Email))
+
+	if nextState == database.AwaitingApproval {
+		c.HTML(http.StatusOK, "awaiting-approval.tmpl", nil)
+		return
+	}
+	redirect(c, "/aut/innlogging")
+}
+
+func (ah AuthHandler) resendOneTimeCode(c *gin.Context) {
+	username := strings.TrimSpace(c.PostForm("username"))
+	email := strings.TrimSpace(c.PostForm("email"))
+
+	code, state, err := ah.DB.ResendOneTimeCode(username, email)
+	if errors.Is(err, database.ErrResendTooSoon) {
+		c.String(http.StatusOK, "Vent litt før du ber om en ny kode")
+		return
+	}
+	if err != nil {
+		// Unknown users get the same answer, so this can't be used to look up emails.
+		slog.InfoContext(c.Request.Context(), "Could not create a new one time code", "err", err)
+		c.String(http.StatusOK, "Om brukeren finnes, er en ny kode sendt")
+		return
+	}
+
+	if state == database.ResettingPasswrod {
+		err = ah.EmailClient.SendPasswordCode(code, email)
+	} else {
+		err = ah.EmailClient.SendAuthEmail(email, code)
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Something went wrong queueing one time code", "err", err)
+	}
+	c.String(http.StatusOK, "Om brukeren finnes, er en ny kode sendt")
+}
+
+func oneTimeCodeErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, database.ErrOneTimeCodeExpired):
+		return "Engangskoden har utløpt. Be om en ny kode."
+	case errors.Is(err, database.ErrOneTimeCodeLocked):
+		return "For mange feil forsøk. Be om en ny kode."
+	case errors.Is(err, database.ErrInvalidOneTimeCode):
+		return "Feil engangskode"
+	default:
+		return "Noe gikk galt. Prøv igjen senere"
+	}
+}
+
+// redirect sends htmx requests to a new page with HX-Redirect, as htmx would
+// otherwise follow a normal redirect and swap the whole page into the form.
+func redirect(c *gin.Context, location string) {
+	if c.GetHeader("HX-Request") == "true" {
+		c.Header("HX-Redirect", location)
+		c.Status(http.StatusOK)
+		return
 	}
-	c.Header("Location", "/aut/innlogging")
+	c.Header("Location", location)
 	c.Status(http.StatusSeeOther)
 }
 
 func (ah AuthHandler) createUser(c *gin.Context) {
+	created := false
+	defer func() { ah.Metrics.Signup(created) }()
 
 	user := database.User{
 		Username: c.PostForm("username"),
@@ -57,63 +129,80 @@ func (ah AuthHandler) createUser(c *gin.Context) {
 	user.Username = strings.TrimSpace(user.Username)
 	user.Email = strings.TrimSpace(user.Email)
 
-	log.Printf("verifiserer og skaper bruker med brukernavn: %s, og epost: %s", user.Username, user.Email)
+	slog.InfoContext(c.Request.Context(), "Validating user form", "username", user.Username, "email", user.Email)
 	//TODO: Denne bør sette error i form = Epost og brukernavn er påkrevde felter.
 	if user.Email == "" || user.Username == "" {
-		log.Print("Could not bind form data to user")
+		slog.InfoContext(c.Request.Context(), "Could not bind form data to user")
 		c.String(http.StatusBadRequest, "Ugyldig epost eller navn")
 		return
 	}
-	v := strings.Split(user.Email, "@")
-
-	if len(v) != 2 {
-		log.Printf("Invalid email: %s", user.Email)
+	address, domain, err := signup.ParseEmail(user.Email)
+	if err != nil {
+		slog.InfoContext(c.Request.Context(), "Invalid email", "email", user.Email)
 		c.String(http.StatusBadRequest, "Ugyldig epost")
 		return
-	} else if v[1] != "soprasteria.com" {
-		log.Printf("User with email-domain: %s tried to sign up.", v[1])
-		c.String(http.StatusBadRequest, "Beklager, du kan ikke registrere deg (enda)")
+	}
+	user.Email = address
+
+	if err := ah.Passwords.Validate(user.Password); err != nil {
+		slog.InfoContext(c.Request.Context(), "Password refused on registration", "err", err)
+		passwordError(c, ah.Passwords.Message(err))
+		return
+	}
+
+	inviteCode := strings.TrimSpace(c.PostForm("inviteCode"))
+	if !ah.Signup.NeedsInvite(domain) {
+		inviteCode = "" // Don't use up an invite that isn't needed
+	} else if inviteCode == "" {
+		slog.InfoContext(c.Request.Context(), "User tried to sign up without an invite code", "domain", domain)
+		c.String(http.StatusBadRequest, "Beklager, du trenger en invitasjonskode for å registrere deg")
 		return
 	}
 
 	//Is username used before?
 	usernameExists, err := ah.DB.UserExistsWithUsername(user.Username)
 	if usernameExists {
-		log.Printf("User with username: %s Already exists.", user.Username)
+		slog.InfoContext(c.Request.Context(), "Username already exists", "username", user.Username)
 		//TODO: Give better response here.
 		c.String(http.StatusUnprocessableEntity, "Brukernavn %s er allerede tatt", user.Username)
 		return
 	}
 	if err != nil {
-		log.Printf("Noe gikk galt under DB kall %s", err.Error())
+		slog.ErrorContext(c.Request.Context(), "Could not check if username exists", "err", err)
 		c.Status(http.StatusInternalServerError)
 		return
 	}
 	//Is email used before?
 	emailExists, _, err := ah.DB.UserExistsWithEmail(user.Email)
 	if emailExists {
-		log.Printf("Email: %s is already in use.", user.Email)
+		slog.InfoContext(c.Request.Context(), "Email is already in use", "email", user.Email)
 		//TODO: Give better response here? Shouldn't inform that this email is in use. Makes scraping possible
 		c.String(http.StatusBadRequest, "Noe gikk galt. Prøv igjen senere")
 		return
 	}
 	if err != nil {
-		log.Printf("Noe gikk galt under DB kall:\n%s", err.Error())
+		slog.ErrorContext(c.Request.Context(), "Could not check if email exists", "err", err)
 		c.Status(http.StatusInternalServerError)
 		return
 	}
 
 	//Create user
-	user, err = ah.DB.CreateUser(user)
+	user, err = ah.DB.CreateUserWithInvite(user, inviteCode)
+	if errors.Is(err, database.ErrInvalidInvite) {
+		slog.InfoContext(c.Request.Context(), "User tried to sign up with an invalid invite code")
+		c.String(http.StatusBadRequest, "Invitasjonskoden er ugyldig, utløpt eller allerede brukt")
+		return
+	}
 	if err != nil {
 		c.String(http.StatusInternalServerError, "Noe gikk galt under lagring av brukereren. Prøv på nytt senere")
-		log.Printf("Error on create: \n%s", err.Error())
+		slog.ErrorContext(c.Request.Context(), "Could not create user", "err", err)
 		return
 	}
+	created = true
 
 	err = ah.EmailClient.SendAuthEmail(user.Email, user.OneTimeCode.String)
 	if err != nil {
-		log.Printf("Error sending email: %s", err)
+		slog.ErrorContext(c.Request.Context(), "Error queueing email", "err", err)
 	}
 	c.HTML(http.StatusOK, "one-time-code.tmpl", gin.H{
 		"username": user.Username,
@@ -122,6 +211,19 @@ func (ah AuthHandler) createUser(c *gin.Context) {
 	})
 }
 
+// Checks the password while the user types it, see the passwordfield template.
+func (ah AuthHandler) validatePassword(c *gin.Context) {
+	err := ah.Passwords.Validate(c.PostForm("password"))
+	c.String(http.StatusOK, "%s", ah.Passwords.Message(err))
+}
+
+// passwordError shows the message below the password field in htmx forms.
+func passwordError(c *gin.Context, message string) {
+	c.Header("HX-Retarget", "#password-error")
+	c.Header("HX-Reswap", "innerHTML")
+	c.String(http.StatusUnprocessableEntity, "%s", message)
+}
+
 func validateRegisterForm(c *gin.Context) (database.User, error) {
 	user := database.User{
 		Username: c.PostForm("username"),
@@ -131,24 +233,20 @@ func validateRegisterForm(c *gin.Context) (database.User, error) {
 	user.Username = strings.TrimSpace(user.Username)
 	user.Email = strings.TrimSpace(user.Email)
 
-	log.Printf("verifiserer og skaper bruker med brukernavn: %s, og epost: %s", user.Username, user.Email)
+	slog.InfoContext(c.Request.Context(), "Validating user form", "username", user.Username, "email", user.Email)
 	//TODO: Denne bør sette error i form = Epost og brukernavn er påkrevde felter.
 	if user.Email == "" || user.Username == "" {
-		log.Print("Could not bind form data to user")
+		slog.InfoContext(c.Request.Context(), "Could not bind form data to user")
 		c.String(http.StatusBadRequest, "Ugyldig epost eller navn")
 		return user, errors.New("ugyldig epost eller navn")
 	}
-	v := strings.Split(user.Email, "@")
-
-	if len(v) != 2 {
-		log.Printf("Invalid email: %s", user.Email)
+	address, _, err := signup.ParseEmail(user.Email)
+	if err != nil {
+		slog.InfoContext(c.Request.Context(), "Invalid email", "email", user.Email)
 		c.String(http.StatusBadRequest, "Ugyldig epost")
 		return user, errors.New("ugyldig epost")
-	} else if v[1] != "soprasteria.com" {
-		log.Printf("User with email-domain: %s tried to sign up.", v[1])
-		c.String(http.StatusBadRequest, "Beklager, du kan ikke registrere deg (enda)")
-		return user, errors.New("beklager, du kan ikke registrere deg (enda)")
 	}
+	user.Email = address
 
 	return user, nil
 }
@@ -176,37 +274,6 @@ func validateRegisterForm(c *gin.Context) (database.User, error) {
 // 	})
 // }
 
-// func validatePassword(c *gin.Context) {
-// 	p := c.PostForm("password")
-// 	if len(p) < 5 {
-// 		returnForm := `
-// 		<div hx-target="this" hx-swap="outerHTML">
-//         	<label for="passord">Ditt passord: </label>
-//         	<input hx-post="/registrer-bruker/password" type="password" name="password" id="password" value="%s" required />
-// 			<div class='error-message'>Passorder er for kort</div>
-//       	</div>
-// 		`
-// 		c.String(http.StatusOK, returnForm, p)
-// 		return
-// 	} else if len([]byte(p)) > 72 {
-// 		returnForm := `
-// 		<div hx-target="this" hx-swap="outerHTML">
-//         	<label for="passord">Ditt passord: </label>
-//         	<input hx-post="/registrer-bruker/password" type="password" name="password" id="password" value="%s" required />
-// 			<div class='error-message'>Passordet er for kort.</div>
-//       	</div>
-// 		`
-// 		c.String(http.StatusOK, returnForm, p)
-// 	}
-// 	returnForm := `
-// 	<div hx-target="this" hx-swap="outerHTML">
-// 		<label for="passord">Ditt passord: </label>
-// 		<input hx-post="/registrer-bruker/password" type="password" name="password" id="password" value="%s" required />
-// 	</div>
-// 		`
-// 	c.String(http.StatusOK, returnForm, p)
-// }
-
 // func (ah AuthHandler) validateUsername(c *gin.Context) {
 // 	username := c.PostForm("username")
 // 	username = strings.TrimSpace(username)
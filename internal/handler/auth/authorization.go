@@ -1,17 +1,33 @@
 package auth
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"strings"
 
+	"github.com/KimBrusevold/webTimer/internal/database"
+	"github.com/KimBrusevold/webTimer/internal/middelware"
 	"github.com/gin-gonic/gin"
 )
 
 func (ah AuthHandler) loginPage(c *gin.Context) {
-	c.HTML(http.StatusOK, "login.tmpl", gin.H{
-		"title": "Logg inn",
+	ah.renderLogin(c, http.StatusOK, "")
+}
+
+// renderLogin shows the login page, with a message above the form if there is one.
+func (ah AuthHandler) renderLogin(c *gin.Context, status int, message string) {
+	ssoName := ""
+	if ah.SSO != nil {
+		ssoName = ah.SSOName
+	}
+	c.HTML(status, "login.tmpl", gin.H{
+		"title":   "Logg inn",
+		"csrf":    middelware.CSRFToken(c),
+		"error":   message,
+		"ssoName": ssoName,
 	})
 }
 
@@ -19,7 +35,7 @@ func (ah AuthHandler) loginUser(c *gin.Context) {
 	email := c.PostForm("email")
 
 	if email == "" {
-		log.Print("Could not bind form data to user")
+		slog.InfoContext(c.Request.Context(), "Could not bind form data to user")
 		c.String(http.StatusBadRequest, "Ugyldig epost eller navn")
 		return
 	}
@@ -27,30 +43,54 @@ func (ah AuthHandler) loginUser(c *gin.Context) {
 	usernameExists, _, err := ah.DB.UserExistsWithEmail(email)
 
 	if err != nil {
-		log.Printf("Kunne ikke logge inn bruker. DB feil: %s", err.Error())
+		slog.ErrorContext(c.Request.Context(), "Could not log in user", "err", err)
 		c.Status(http.StatusInternalServerError)
 		return
 	}
 	if !usernameExists {
-		log.Printf("Exists no user with email address: %s", email)
+		slog.InfoContext(c.Request.Context(), "Exists no user with email address", "email", email)
+		ah.Metrics.Login("password", false)
 		c.Header("Location", "/registrer-bruker")
 		c.Status(http.StatusSeeOther)
 		return
 	}
+	account := lockoutAccount(email)
+	if locked, wait := ah.Lockout.Locked(account); locked {
+		slog.WarnContext(c.Request.Context(), "Login attempt on a locked account")
+		ah.Metrics.Login("password", false)
+		middelware.TooManyRequests(c, wait)
+		return
+	}
+
 	password := c.PostForm("password")
 	user, err := ah.DB.UserAuthProcess(email, password)
 	if err != nil {
+		ah.Lockout.Failed(account)
+		ah.Metrics.Login("password", false)
 		c.String(http.StatusUnauthorized, "Error on authorization: %s", err.Error())
 		return
 	}
+	ah.Lockout.Succeeded(account)
+	ah.Metrics.Login("password", true)
 
-	c.SetCookie("userAuthCookie", user.Authcode.String, 0, "/", hostUrl, true, true)
-	c.SetCookie("userId", fmt.Sprintf("%d", user.ID), 0, "/", hostUrl, true, true)
+	ah.setAuthCookies(c, user)
 
 	c.Header("Location", "/")
 	c.Status(http.StatusSeeOther)
 }
 
+// Failed logins lock the account by its email. Proving access to the email, by a
+// one-time code or a login link, lifts the lock, so no one can keep a user out by
+// guessing wrong on purpose.
+func lockoutAccount(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func (ah AuthHandler) setAuthCookies(c *gin.Context, user *database.User) {
+	ah.Cookies.Set(c, "userAuthCookie", user.Authcode.String, 0, true)
+	ah.Cookies.Set(c, "userId", fmt.Sprintf("%d", user.ID), 0, true)
+}
+
 func (ah AuthHandler) newPassword(c *gin.Context) {
 	c.HTML(http.StatusOK, "forgot-password.tmpl", nil)
 }
@@ -58,24 +98,35 @@ func (ah AuthHandler) newPassword(c *gin.Context) {
 func (ah AuthHandler) setnewPassword(c *gin.Context) {
 	user, err := validateRegisterForm(c)
 	if err != nil {
-		log.Print(err)
+		slog.InfoContext(c.Request.Context(), "Invalid password reset form", "err", err)
 		return //TODO responder med en tilbakemelding
 	}
 
 	user.OneTimeCode.String = strings.TrimSpace(c.PostForm("oneTimeCode"))
 	if user.OneTimeCode.String == "" {
-		log.Print("Authcode cannot be empty")
+		slog.InfoContext(c.Request.Context(), "Authcode cannot be empty")
 		return //TODO return error message
 	}
 
+	if err := ah.Passwords.Validate(user.Password); err != nil {
+		slog.InfoContext(c.Request.Context(), "Password refused on reset", "err", err)
+		passwordError(c, ah.Passwords.Message(err))
+		return
+	}
+
 	err = ah.DB.UpdatePassword(user)
 	if err != nil {
-		log.Print("Error updating password")
-		return //TODO return error message
+		slog.WarnContext(c.Request.Context(), "Error updating password", "err", err)
+		c.HTML(http.StatusOK, "forgot-password-response.tmpl", gin.H{
+			"username": user.Username,
+			"email":    user.Email,
+			"error":    oneTimeCodeErrorMessage(err),
+		})
+		return
 	}
+	ah.Lockout.Succeeded(lockoutAccount(user.Email))
 
-	c.Header("Location", "/aut/innlogging")
-	c.Status(http.StatusSeeOther)
+	redirect(c, "/aut/innlogging")
 }
 
 func (ah AuthHandler) sendNewPasswordEmail(c *gin.Context) {
@@ -94,15 +145,22 @@ func (ah AuthHandler) sendNewPasswordEmail(c *gin.Context) {
 	email = strings.TrimSpace(email)
 
 	code, err := ah.DB.SetNewOnetimeCode(username, email)
-
+	if errors.Is(err, database.ErrResendTooSoon) {
+		slog.InfoContext(c.Request.Context(), "New one time code requested too soon after the last one")
+		return
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		slog.InfoContext(c.Request.Context(), "Password reset requested for a user that can not reset it")
+		return
+	}
 	if err != nil {
-		log.Printf("Something went wrong setting new one time code. %s", err)
+		slog.ErrorContext(c.Request.Context(), "Something went wrong setting new one time code", "err", err)
 		return
 	}
 
 	err = ah.EmailClient.SendPasswordCode(code, email)
 	if err != nil {
-		log.Printf("Something went wrong sending one time code. %s", err)
+		slog.ErrorContext(c.Request.Context(), "Something went wrong queueing one time code", "err", err)
 		return
 	}
 }
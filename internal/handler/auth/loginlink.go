@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/KimBrusevold/webTimer/internal/middelware"
+	"github.com/gin-gonic/gin"
+)
+
+func (ah AuthHandler) sendLoginLink(c *gin.Context) {
+	email := strings.TrimSpace(c.PostForm("email"))
+
+	// Always give the same answer, so the form can't be used to find registered emails.
+	c.HTML(http.StatusOK, "login-link-sent.tmpl", gin.H{
+		"email": email,
+	})
+
+	if email == "" {
+		return
+	}
+
+	userID, err := ah.DB.ConfirmedUserIdByEmail(email)
+	if errors.Is(err, sql.ErrNoRows) {
+		slog.InfoContext(c.Request.Context(), "Login link requested for an email without a confirmed user")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not look up user for login link", "err", err)
+		return
+	}
+
+	token, encoded, err := ah.LoginLinks.New(userID)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not create login token", "err", err)
+		return
+	}
+	if err := ah.DB.CreateLoginToken(userID, token.Nonce, token.Expires); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not store login token", "err", err)
+		return
+	}
+
+	link := ah.absoluteUrl("/aut/innlogging/lenke?token=" + url.QueryEscape(encoded))
+	if err := ah.EmailClient.SendLoginLink(link, email); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Something went wrong queueing login link", "err", err)
+	}
+}
+
+// Opening the link only shows a confirmation page. Email clients and link scanners
+// often open links on their own, and that should not use up the link.
+func (ah AuthHandler) loginLinkPage(c *gin.Context) {
+	token := c.Query("token")
+	if _, err := ah.LoginLinks.Verify(token, time.Now().UTC()); err != nil {
+		slog.WarnContext(c.Request.Context(), "Invalid login link", "err", err)
+		ah.renderLogin(c, http.StatusBadRequest, "Innloggingslenken er ugyldig eller utløpt. Be om en ny.")
+		return
+	}
+
+	c.HTML(http.StatusOK, "login-link.tmpl", gin.H{
+		"title": "Logg inn",
+		"token": token,
+		"csrf":  middelware.CSRFToken(c),
+	})
+}
+
+func (ah AuthHandler) loginWithLink(c *gin.Context) {
+	now := time.Now().UTC()
+	token, err := ah.LoginLinks.Verify(c.PostForm("token"), now)
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "Invalid login link", "err", err)
+		ah.Metrics.Login("link", false)
+		ah.renderLogin(c, http.StatusBadRequest, "Innloggingslenken er ugyldig eller utløpt. Be om en ny.")
+		return
+	}
+
+	user, err := ah.DB.ConsumeLoginToken(token.UserID, token.Nonce, now)
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "Could not log in with link", "err", err)
+		ah.Metrics.Login("link", false)
+		ah.renderLogin(c, http.StatusBadRequest, "Innloggingslenken er allerede brukt. Be om en ny.")
+		return
+	}
+
+	ah.Lockout.Succeeded(lockoutAccount(user.Email))
+	ah.Metrics.Login("link", true)
+	ah.setAuthCookies(c, user)
+
+	c.Header("Location", "/")
+	c.Status(http.StatusSeeOther)
+}
+
+// absoluteUrl builds a link to this site from HOSTURL.
+func (ah AuthHandler) absoluteUrl(path string) string {
+	return middelware.BaseUrl(ah.HostUrl) + path
+}
@@ -0,0 +1,356 @@
+package auth
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/KimBrusevold/webTimer/internal/achievements"
+	"github.com/KimBrusevold/webTimer/internal/database"
+	"github.com/KimBrusevold/webTimer/internal/middelware"
+	"github.com/KimBrusevold/webTimer/internal/model"
+	"github.com/KimBrusevold/webTimer/internal/signup"
+	"github.com/gin-gonic/gin"
+)
+
+// How recent the login of a user without a password must be to change the email.
+const freshLogin = 10 * time.Minute
+
+// SetupProfileRoutes adds the pages where logged in users manage their own account.
+func (a AuthHandler) SetupProfileRoutes(rg *gin.RouterGroup) {
+	authMW := middelware.AuthMiddelware{
+		DB: a.DB,
+	}
+	rg.Use(authMW.Authenticate)
+	limited := rg.Group("", a.RateLimit.Limit)
+
+	rg.GET("", a.profilePage)
+	limited.POST("/brukernavn", a.changeUsername)
+	limited.POST("/epost", a.changeEmail)
+	limited.POST("/epost/bekreft", a.confirmEmailChange)
+	limited.POST("/passord", a.changePassword)
+	limited.POST("/personvern", a.changePrivacy)
+	limited.POST("/lag", a.changeTeam)
+	limited.POST("/klasse", a.changeCategories)
+	limited.POST("/slett", a.deleteAccount)
+}
+
+func (ah AuthHandler) profilePage(c *gin.Context) {
+	user, err := ah.DB.GetUser(profileUserID(c))
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not get user for profile page", "err", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	earned, err := ah.DB.UserAchievements(user.ID)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not get achievements for profile page", "err", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	teams, err := ah.DB.RetrieveTeams()
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not get teams for profile page", "err", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	courses, err := ah.DB.RetrieveCourses()
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not get courses for profile page", "err", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	var handicaps []model.CourseHandicap
+	for _, course := range courses {
+		ms, err := ah.DB.Handicap(user.ID, course.ID)
+		if errors.Is(err, database.ErrNoHandicap) {
+			continue
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Could not get handicap for profile page", "err", err)
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		handicaps = append(handicaps, model.CourseHandicap{Course: course.Name, Handicap: model.FormatTime(ms)})
+	}
+
+	c.HTML(http.StatusOK, "profile.tmpl", gin.H{
+		"title":           "Min profil",
+		"username":        user.Username,
+		"email":           user.Email,
+		"privacy":         int(user.Privacy),
+		"alias":           user.Alias.String,
+		"badges":          achievements.Summarize(nil, earned),
+		"teams":           teams,
+		"team":            user.TeamID.Int64,
+		"ageGroups":       database.AgeGroups,
+		"genders":         database.Genders,
+		"ageGroup":        user.AgeGroup.String,
+		"gender":          user.Gender.String,
+		"division":        user.Division.String,
+		"handicaps":       handicaps,
+		"handicapRuns":    database.HandicapRuns,
+		"minHandicapRuns": database.MinHandicapRuns,
+		"hasPassword":     user.HasPassword,
+		"freshLogin":      int(freshLogin.Minutes()),
+	})
+}
+
+func (ah AuthHandler) changeUsername(c *gin.Context) {
+	username := strings.TrimSpace(c.PostForm("username"))
+	if username == "" {
+		profileStatus(c, http.StatusUnprocessableEntity, "Brukernavnet kan ikke være tomt")
+		return
+	}
+
+	err := ah.DB.UpdateUsername(profileUserID(c), username)
+	if errors.Is(err, database.ErrUsernameTaken) {
+		profileStatus(c, http.StatusUnprocessableEntity, "Brukernavnet er tatt")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not change username", "err", err)
+		profileStatus(c, http.StatusInternalServerError, "Noe gikk galt. Prøv igjen senere")
+		return
+	}
+	profileStatus(c, http.StatusOK, "Brukernavnet er endret")
+}
+
+func (ah AuthHandler) changeEmail(c *gin.Context) {
+	userID := profileUserID(c)
+	user, err := ah.DB.GetUser(userID)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not get user to change email", "err", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	// With a stolen session the account could otherwise be moved to another email,
+	// and the password reset from there
+	err = ah.DB.Reauthenticate(userID, c.PostForm("currentPassword"), freshLogin)
+	switch {
+	case errors.Is(err, database.ErrWrongPassword):
+		ah.renderEmailForm(c, user, "Feil nåværende passord")
+		return
+	case errors.Is(err, database.ErrStaleLogin):
+		ah.renderEmailForm(c, user, "Logg inn på nytt for å endre eposten")
+		return
+	case err != nil:
+		slog.ErrorContext(c.Request.Context(), "Could not check password to change email", "err", err)
+		ah.renderEmailForm(c, user, "Noe gikk galt. Prøv igjen senere")
+		return
+	}
+
+	address, domain, err := signup.ParseEmail(c.PostForm("email"))
+	if err != nil {
+		ah.renderEmailForm(c, user, "Ugyldig epost")
+		return
+	}
+	// Users who got in with an invite can keep using their own domain
+	_, currentDomain, _ := signup.ParseEmail(user.Email)
+	if ah.Signup.NeedsInvite(domain) && domain != currentDomain {
+		ah.renderEmailForm(c, user, "Eposten kan ikke brukes her. "+ah.Signup.EmailHint())
+		return
+	}
+
+	code, err := ah.DB.RequestEmailChange(userID, address)
+	switch {
+	case errors.Is(err, database.ErrEmailTaken):
+		ah.renderEmailForm(c, user, "Eposten brukes av en annen bruker")
+		return
+	case errors.Is(err, database.ErrResendTooSoon):
+		ah.renderEmailForm(c, user, "Vent litt før du ber om en ny kode")
+		return
+	case err != nil:
+		slog.ErrorContext(c.Request.Context(), "Could not start email change", "err", err)
+		ah.renderEmailForm(c, user, "Noe gikk galt. Prøv igjen senere")
+		return
+	}
+
+	if err := ah.EmailClient.SendEmailChangeCode(code, address); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Something went wrong queueing email change code", "err", err)
+	}
+	c.HTML(http.StatusOK, "profile-email-code.tmpl", gin.H{
+		"email": address,
+	})
+}
+
+func (ah AuthHandler) confirmEmailChange(c *gin.Context) {
+	code := strings.TrimSpace(c.PostForm("oneTimeCode"))
+	oldEmail, newEmail, err := ah.DB.ConfirmEmailChange(profileUserID(c), code)
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "Could not confirm email change", "err", err)
+		message := oneTimeCodeErrorMessage(err)
+		if errors.Is(err, database.ErrEmailTaken) {
+			message = "Eposten brukes av en annen bruker"
+		}
+		c.HTML(http.StatusOK, "profile-email-code.tmpl", gin.H{
+			"email": c.PostForm("email"),
+			"error": message,
+		})
+		return
+	}
+
+	if err := ah.EmailClient.SendEmailChanged(oldEmail, newEmail); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Something went wrong queueing email changed notice", "err", err)
+	}
+	user, err := ah.DB.GetUser(profileUserID(c))
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not get user after changing email", "err", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	data := emailFormData(user)
+	data["message"] = "Eposten er endret"
+	c.HTML(http.StatusOK, "profile-email.tmpl", data)
+}
+
+func (ah AuthHandler) renderEmailForm(c *gin.Context, user *database.User, errorMessage string) {
+	data := emailFormData(user)
+	data["error"] = errorMessage
+	c.HTML(http.StatusOK, "profile-email.tmpl", data)
+}
+
+// The email form asks for the current password, or from users without one, a
+// login within freshLogin.
+func emailFormData(user *database.User) gin.H {
+	return gin.H{
+		"email":       user.Email,
+		"hasPassword": user.HasPassword,
+		"freshLogin":  int(freshLogin.Minutes()),
+	}
+}
+
+func (ah AuthHandler) changePassword(c *gin.Context) {
+	password := c.PostForm("password")
+	if err := ah.Passwords.Validate(password); err != nil {
+		passwordError(c, ah.Passwords.Message(err))
+		return
+	}
+
+	userID := profileUserID(c)
+	authcode, err := ah.DB.ChangePassword(userID, c.PostForm("currentPassword"), password)
+	if errors.Is(err, database.ErrWrongPassword) {
+		profileStatus(c, http.StatusUnprocessableEntity, "Feil nåværende passord")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not change password", "err", err)
+		profileStatus(c, http.StatusInternalServerError, "Noe gikk galt. Prøv igjen senere")
+		return
+	}
+
+	// The old authcode is replaced, which logs out every other session
+	user := &database.User{ID: userID}
+	user.Authcode.String = authcode
+	ah.setAuthCookies(c, user)
+	profileStatus(c, http.StatusOK, "Passordet er endret")
+}
+
+func (ah AuthHandler) changePrivacy(c *gin.Context) {
+	privacy, err := strconv.Atoi(c.PostForm("privacy"))
+	if err != nil || privacy < int(database.Public) || privacy > int(database.Hidden) {
+		profileStatus(c, http.StatusUnprocessableEntity, "Velg hvem som kan se tidene dine")
+		return
+	}
+
+	err = ah.DB.UpdatePrivacy(profileUserID(c), database.Privacy(privacy), c.PostForm("alias"))
+	switch {
+	case errors.Is(err, database.ErrAliasRequired):
+		profileStatus(c, http.StatusUnprocessableEntity, "Skriv inn et alias")
+		return
+	case errors.Is(err, database.ErrAliasTaken):
+		profileStatus(c, http.StatusUnprocessableEntity, "Aliaset er tatt")
+		return
+	case err != nil:
+		slog.ErrorContext(c.Request.Context(), "Could not change privacy", "err", err)
+		profileStatus(c, http.StatusInternalServerError, "Noe gikk galt. Prøv igjen senere")
+		return
+	}
+	profileStatus(c, http.StatusOK, "Personvern er lagret")
+}
+
+func (ah AuthHandler) deleteAccount(c *gin.Context) {
+	userID := profileUserID(c)
+	user, err := ah.DB.GetUser(userID)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not get user to delete", "err", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	if strings.TrimSpace(c.PostForm("confirmUsername")) != user.Username {
+		profileStatus(c, http.StatusUnprocessableEntity, "Skriv brukernavnet ditt for å bekrefte")
+		return
+	}
+
+	keepTimes := c.PostForm("times") == "anonymize"
+	if err := ah.DB.DeleteUser(userID, keepTimes); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not delete user", "err", err)
+		profileStatus(c, http.StatusInternalServerError, "Noe gikk galt. Prøv igjen senere")
+		return
+	}
+	slog.InfoContext(c.Request.Context(), "User deleted their account", "userId", userID, "keptTimes", keepTimes)
+
+	ah.Cookies.Set(c, "userAuthCookie", "", -1, true)
+	ah.Cookies.Set(c, "userId", "", -1, true)
+	redirect(c, "/")
+}
+
+func (ah AuthHandler) changeTeam(c *gin.Context) {
+	teamID, err := strconv.ParseInt(c.PostForm("team"), 10, 64)
+	if err != nil {
+		profileStatus(c, http.StatusUnprocessableEntity, "Velg et lag")
+		return
+	}
+
+	err = ah.DB.UpdateTeam(profileUserID(c), teamID)
+	if errors.Is(err, database.ErrUnknownTeam) {
+		profileStatus(c, http.StatusUnprocessableEntity, "Laget finnes ikke")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not change team", "err", err)
+		profileStatus(c, http.StatusInternalServerError, "Noe gikk galt. Prøv igjen senere")
+		return
+	}
+	profileStatus(c, http.StatusOK, "Laget er lagret")
+}
+
+func (ah AuthHandler) changeCategories(c *gin.Context) {
+	division := strings.TrimSpace(c.PostForm("division"))
+	if utf8.RuneCountInString(division) > 40 {
+		profileStatus(c, http.StatusUnprocessableEntity, "Divisjonen kan ha maks 40 tegn")
+		return
+	}
+
+	err := ah.DB.UpdateCategories(profileUserID(c), c.PostForm("agegroup"), c.PostForm("gender"), division)
+	if errors.Is(err, database.ErrUnknownCategory) {
+		profileStatus(c, http.StatusUnprocessableEntity, "Ukjent klasse")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not change categories", "err", err)
+		profileStatus(c, http.StatusInternalServerError, "Noe gikk galt. Prøv igjen senere")
+		return
+	}
+	profileStatus(c, http.StatusOK, "Klassene er lagret")
+}
+
+// profileStatus answers a profile form with a message shown below it.
+func profileStatus(c *gin.Context, status int, message string) {
+	c.HTML(status, "profile-status.tmpl", gin.H{
+		"message": message,
+		"error":   status != http.StatusOK,
+	})
+}
+
+func profileUserID(c *gin.Context) int64 {
+	return int64(c.GetInt("userId"))
+}
@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/KimBrusevold/webTimer/internal/database"
+	"github.com/KimBrusevold/webTimer/internal/oidc"
+	"github.com/KimBrusevold/webTimer/internal/signup"
+	"github.com/gin-gonic/gin"
+)
+
+// Holds the state, nonce and PKCE verifier while the user logs in at the provider.
+const ssoCookie = "ssologin"
+
+var (
+	errSSOEmailNotVerified = errors.New("the identity provider has not verified the email")
+	errSSOSignupNotAllowed = errors.New("signup policy does not allow the email")
+)
+
+func (ah AuthHandler) startSSO(c *gin.Context) {
+	login, err := oidc.NewLogin()
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not start single sign-on", "err", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	value := login.State + "." + login.Nonce + "." + login.Verifier
+	ah.Cookies.Set(c, ssoCookie, value, 600, true)
+
+	c.Header("Location", ah.SSO.AuthCodeURL(login))
+	c.Status(http.StatusSeeOther)
+}
+
+func (ah AuthHandler) ssoCallback(c *gin.Context) {
+	loggedIn := false
+	defer func() { ah.Metrics.Login("sso", loggedIn) }()
+
+	value, err := c.Cookie(ssoCookie)
+	ah.Cookies.Set(c, ssoCookie, "", -1, true)
+	parts := strings.Split(value, ".")
+	if err != nil || len(parts) != 3 {
+		slog.WarnContext(c.Request.Context(), "Single sign-on callback without a login in progress")
+		ah.renderLogin(c, http.StatusBadRequest, "Innloggingen tok for lang tid. Prøv igjen.")
+		return
+	}
+	login := oidc.Login{State: parts[0], Nonce: parts[1], Verifier: parts[2]}
+
+	if providerErr := c.Query("error"); providerErr != "" {
+		slog.WarnContext(c.Request.Context(), "Single sign-on failed at the provider", "error", providerErr, "description", c.Query("error_description"))
+		ah.renderLogin(c, http.StatusBadRequest, "Innloggingen hos "+ah.SSOName+" ble avbrutt.")
+		return
+	}
+
+	identity, err := ah.SSO.Exchange(c.Request.Context(), login, c.Query("state"), c.Query("code"))
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "Could not complete single sign-on", "err", err)
+		ah.renderLogin(c, http.StatusBadRequest, "Kunne ikke logge inn med "+ah.SSOName+". Prøv igjen.")
+		return
+	}
+
+	user, err := ah.ssoUser(identity)
+	switch {
+	case errors.Is(err, database.ErrAwaitingApproval):
+		ah.renderLogin(c, http.StatusForbidden, "Kontoen din venter på godkjenning fra en administrator.")
+		return
+	case errors.Is(err, errSSOEmailNotVerified), errors.Is(err, signup.ErrInvalidEmail):
+		slog.WarnContext(c.Request.Context(), "Single sign-on refused", "err", err)
+		ah.renderLogin(c, http.StatusForbidden, ah.SSOName+" har ikke bekreftet eposten din, så den kan ikke brukes her.")
+		return
+	case errors.Is(err, errSSOSignupNotAllowed):
+		slog.WarnContext(c.Request.Context(), "Single sign-on refused", "err", err)
+		ah.renderLogin(c, http.StatusForbidden, "Eposten din kan ikke registreres uten invitasjonskode. Registrer deg med koden først.")
+		return
+	case errors.Is(err, database.ErrOIDCAlreadyLinked):
+		slog.WarnContext(c.Request.Context(), "Single sign-on refused", "err", err)
+		ah.renderLogin(c, http.StatusConflict, "Eposten din er allerede koblet til en annen konto hos "+ah.SSOName+".")
+		return
+	case err != nil:
+		slog.ErrorContext(c.Request.Context(), "Could not log in with single sign-on", "err", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	loggedIn = true
+	ah.setAuthCookies(c, user)
+
+	c.Header("Location", "/")
+	c.Status(http.StatusSeeOther)
+}
+
+// ssoUser logs in the user linked to the identity. If there is none, the user
+// with the same verified email is linked, or else a new user is created.
+func (ah AuthHandler) ssoUser(identity oidc.Identity) (*database.User, error) {
+	user, err := ah.DB.LoginWithOIDC(identity.Issuer, identity.Subject)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return user, err
+	}
+
+	if !identity.EmailVerified {
+		return nil, errSSOEmailNotVerified
+	}
+	address, domain, err := signup.ParseEmail(identity.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	state := database.Confirmed
+	if ah.Signup.RequireApproval {
+		state = database.AwaitingApproval
+	}
+
+	user, err = ah.DB.LinkOIDC(address, identity.Issuer, identity.Subject, state)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return user, err
+	}
+
+	if ah.Signup.NeedsInvite(domain) {
+		return nil, errSSOSignupNotAllowed
+	}
+	newUser := database.User{
+		Username: ssoUsername(identity, address),
+		Email:    address,
+	}
+	slog.Info("Creating user from single sign-on", "username", newUser.Username)
+	return ah.DB.CreateOIDCUser(newUser, identity.Issuer, identity.Subject, state)
+}
+
+func ssoUsername(identity oidc.Identity, email string) string {
+	for _, name := range []string{identity.PreferredUsername, identity.Name, email} {
+		name, _, _ = strings.Cut(name, "@")
+		if name = strings.TrimSpace(name); name != "" {
+			return name
+		}
+	}
+	return "bruker"
+}
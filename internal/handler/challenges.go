@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/KimBrusevold/webTimer/internal/challenges"
+	"github.com/KimBrusevold/webTimer/internal/database"
+	"github.com/KimBrusevold/webTimer/internal/middelware"
+	"github.com/KimBrusevold/webTimer/internal/model"
+	"github.com/gin-gonic/gin"
+)
+
+type ChallengesHandler struct {
+	DB         *database.TimerDB
+	Challenges challenges.Service
+}
+
+func (ch ChallengesHandler) SetupRoutes(rg *gin.RouterGroup) {
+	authMW := middelware.AuthMiddelware{
+		DB: ch.DB,
+	}
+	rg.Use(authMW.Authenticate)
+	rg.GET("", ch.challengesPage)
+	rg.POST("", ch.createChallenge)
+	rg.POST("/:id/godta", ch.answerChallenge(true))
+	rg.POST("/:id/avsla", ch.answerChallenge(false))
+}
+
+func (ch ChallengesHandler) challengesPage(c *gin.Context) {
+	// Deadlines pass without anyone running, so end those before showing them
+	if err := ch.Challenges.Expire(c.Request.Context()); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not expire challenges", "err", err)
+	}
+
+	userID := int64(c.GetInt("userId"))
+	all, err := ch.DB.RetrieveChallenges(userID)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not get challenges from db", "err", err)
+		c.String(http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+	courses, err := ch.DB.RetrieveCourses()
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not get courses from db", "err", err)
+		c.String(http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+
+	var open, done []model.ChallengeDisplay
+	for _, chal := range all {
+		d := toChallengeDisplay(chal, userID)
+		if chal.State == database.ChallengePending || chal.State == database.ChallengeAccepted {
+			open = append(open, d)
+		} else {
+			done = append(done, d)
+		}
+	}
+
+	c.HTML(http.StatusOK, "challenges.tmpl", gin.H{
+		"title":   "Utfordringer",
+		"open":    open,
+		"done":    done,
+		"courses": courses,
+		"csrf":    middelware.CSRFToken(c),
+	})
+}
+
+func (ch ChallengesHandler) createChallenge(c *gin.Context) {
+	courseID, err := strconv.ParseInt(c.PostForm("course"), 10, 64)
+	if err != nil {
+		challengeStatus(c, "Ukjent løype")
+		return
+	}
+	days, err := strconv.Atoi(c.PostForm("days"))
+	if err != nil || days < 1 || days > 30 {
+		challengeStatus(c, "Fristen må være mellom 1 og 30 dager")
+		return
+	}
+
+	userID := int64(c.GetInt("userId"))
+	deadline := time.Now().AddDate(0, 0, days)
+	_, err = ch.Challenges.Challenge(c.Request.Context(), userID, c.PostForm("opponent"), courseID, deadline)
+	if errors.Is(err, database.ErrUnknownUser) {
+		challengeStatus(c, "Fant ingen med det brukernavnet")
+		return
+	}
+	if errors.Is(err, challenges.ErrSelf) {
+		challengeStatus(c, "Du kan ikke utfordre deg selv")
+		return
+	}
+	if errors.Is(err, database.ErrNoBestTime) {
+		challengeStatus(c, "Du må ha fullført et løp på løypen for å utfordre noen på den")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not create challenge", "err", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	reload(c)
+}
+
+func (ch ChallengesHandler) answerChallenge(accept bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			c.String(http.StatusBadRequest, "Ugyldig id")
+			return
+		}
+
+		err = ch.Challenges.Answer(c.Request.Context(), id, int64(c.GetInt("userId")), accept)
+		if errors.Is(err, database.ErrChallengeNotOpen) {
+			c.String(http.StatusOK, "Utfordringen kan ikke besvares")
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Could not answer challenge", "challengeId", id, "err", err)
+			c.String(http.StatusOK, "Noe gikk galt")
+			return
+		}
+
+		reload(c)
+	}
+}
+
+func challengeStatus(c *gin.Context, message string) {
+	c.HTML(http.StatusUnprocessableEntity, "profile-status.tmpl", gin.H{
+		"message": message,
+		"error":   true,
+	})
+}
+
+// The forms on the page are posted with htmx, so it is reloaded with HX-Redirect
+// to show the change.
+func reload(c *gin.Context) {
+	c.Header("HX-Redirect", "/utfordringer")
+	c.Status(http.StatusOK)
+}
+
+func toChallengeDisplay(chal database.Challenge, userID int64) model.ChallengeDisplay {
+	d := model.ChallengeDisplay{
+		ID:         chal.ID,
+		Challenger: chal.Challenger,
+		Opponent:   chal.Opponent,
+		Course:     chal.Course,
+		Target:     model.FormatTime(chal.Target),
+		Deadline:   model.FormatDate(chal.Deadline),
+	}
+
+	switch chal.State {
+	case database.ChallengePending:
+		d.Status = "Venter på svar fra " + chal.Opponent
+		if chal.OpponentID == userID {
+			d.Status = "Venter på svaret ditt"
+			d.CanAnswer = true
+		}
+	case database.ChallengeAccepted:
+		d.Status = "Pågår"
+	case database.ChallengeDeclined:
+		d.Status = "Avslått"
+	case database.ChallengeExpired:
+		d.Status = "Ikke besvart i tide"
+	case database.ChallengeFinished:
+		if chal.WinnerID.Int64 == chal.OpponentID {
+			d.Status = chal.Opponent + " vant med " + model.FormatTime(chal.WinningTime.Int64)
+		} else {
+			d.Status = chal.Challenger + " vant, tiden ble ikke slått"
+		}
+	}
+	return d
+}
@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/KimBrusevold/webTimer/internal/database"
+	"github.com/gin-gonic/gin"
+)
+
+// HealthHandler answers the probes from the container orchestrator.
+type HealthHandler struct {
+	DB       *database.TimerDB
+	stopping atomic.Bool
+}
+
+func (hh *HealthHandler) SetupRoutes(rg *gin.RouterGroup) {
+	rg.GET("/healthz", hh.live)
+	rg.GET("/readyz", hh.ready)
+}
+
+// Stopping makes readyz fail, so no new requests are sent while the server
+// finishes the ones it has.
+func (hh *HealthHandler) Stopping() {
+	hh.stopping.Store(true)
+}
+
+// live answers as long as the server runs.
+func (hh *HealthHandler) live(c *gin.Context) {
+	c.String(http.StatusOK, "ok")
+}
+
+// ready answers when the server can take requests, which needs the database.
+func (hh *HealthHandler) ready(c *gin.Context) {
+	if hh.stopping.Load() {
+		c.String(http.StatusServiceUnavailable, "shutting down")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Second)
+	defer cancel()
+	if err := hh.DB.Ping(ctx); err != nil {
+		slog.ErrorContext(ctx, "Readiness check could not reach the database", "err", err)
+		c.String(http.StatusServiceUnavailable, "database unavailable")
+		return
+	}
+	c.String(http.StatusOK, "ok")
+}
@@ -0,0 +1,314 @@
+package handler
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/KimBrusevold/webTimer/internal/database"
+	"github.com/KimBrusevold/webTimer/internal/metrics"
+	"github.com/KimBrusevold/webTimer/internal/middelware"
+	"github.com/KimBrusevold/webTimer/internal/model"
+	"github.com/KimBrusevold/webTimer/internal/signup"
+	"github.com/gin-gonic/gin"
+)
+
+// HeatsHandler lets an organizer start the timers of a group at once. Each
+// participant ends their own leg by scanning the finish as usual, so the runs
+// count on the leaderboards like any other.
+type HeatsHandler struct {
+	DB      *database.TimerDB
+	Metrics *metrics.Metrics
+}
+
+func (hh HeatsHandler) SetupRoutes(rg *gin.RouterGroup) {
+	authMW := middelware.AuthMiddelware{
+		DB: hh.DB,
+	}
+	rg.Use(authMW.Authenticate)
+	rg.GET("", hh.heatsPage)
+	rg.POST("", hh.createHeat)
+	rg.POST("/bli-med", hh.joinHeat)
+	rg.GET("/:code", hh.heatPage)
+	rg.GET("/:code/resultater", hh.heatResults)
+	rg.POST("/:code/meld-av", hh.leaveHeat)
+	rg.POST("/:code/start", hh.startHeat)
+}
+
+func (hh HeatsHandler) heatsPage(c *gin.Context) {
+	userID := int64(c.GetInt("userId"))
+	heats, err := hh.DB.RetrieveHeats(userID)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not get heats from db", "err", err)
+		c.String(http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+	courses, err := hh.DB.RetrieveCourses()
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not get courses from db", "err", err)
+		c.String(http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+
+	displays := make([]model.HeatDisplay, len(heats))
+	for i, h := range heats {
+		displays[i] = toHeatDisplay(h, userID)
+	}
+
+	c.HTML(http.StatusOK, "heats.tmpl", gin.H{
+		"title":   "Heat",
+		"heats":   displays,
+		"courses": courses,
+		"csrf":    middelware.CSRFToken(c),
+	})
+}
+
+func (hh HeatsHandler) createHeat(c *gin.Context) {
+	name := strings.TrimSpace(c.PostForm("name"))
+	if name == "" {
+		heatStatus(c, "Heatet må ha et navn")
+		return
+	}
+	courseID, err := strconv.ParseInt(c.PostForm("course"), 10, 64)
+	if err != nil {
+		heatStatus(c, "Ukjent løype")
+		return
+	}
+	code, err := signup.NewInviteCode()
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not create heat code", "err", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	_, err = hh.DB.CreateHeat(database.Heat{
+		Code:        code,
+		Name:        name,
+		CourseID:    courseID,
+		OrganizerID: int64(c.GetInt("userId")),
+		Created:     time.Now().UnixMilli(),
+	})
+	if errors.Is(err, database.ErrUnknownCourse) {
+		heatStatus(c, "Ukjent løype")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not create heat", "err", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	showHeat(c, code)
+}
+
+func (hh HeatsHandler) joinHeat(c *gin.Context) {
+	heat, err := hh.DB.JoinHeat(c.PostForm("code"), int64(c.GetInt("userId")))
+	if errors.Is(err, database.ErrUnknownHeat) {
+		heatStatus(c, "Fant ingen heat med den koden")
+		return
+	}
+	if errors.Is(err, database.ErrHeatStarted) {
+		heatStatus(c, "Heatet har allerede startet")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not join heat", "err", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	showHeat(c, heat.Code)
+}
+
+func (hh HeatsHandler) heatPage(c *gin.Context) {
+	heat, results, ok := hh.heat(c)
+	if !ok {
+		return
+	}
+
+	userID := int64(c.GetInt("userId"))
+	d := toHeatDisplay(heat, userID)
+	for _, res := range results {
+		if res.UserID == userID {
+			d.Joined = true
+		}
+	}
+
+	c.HTML(http.StatusOK, "heat.tmpl", gin.H{
+		"title":    heat.Name,
+		"heat":     d,
+		"results":  toHeatResultDisplays(heat, results),
+		"finished": heatFinished(heat, results),
+		"csrf":     middelware.CSRFToken(c),
+	})
+}
+
+// The heat page polls this while the heat is running, so the placings show up
+// as the participants cross the finish line.
+func (hh HeatsHandler) heatResults(c *gin.Context) {
+	heat, results, ok := hh.heat(c)
+	if !ok {
+		return
+	}
+
+	c.HTML(http.StatusOK, "heat-results.tmpl", gin.H{
+		"heat":     toHeatDisplay(heat, int64(c.GetInt("userId"))),
+		"results":  toHeatResultDisplays(heat, results),
+		"finished": heatFinished(heat, results),
+	})
+}
+
+func (hh HeatsHandler) leaveHeat(c *gin.Context) {
+	heat, err := hh.DB.RetrieveHeat(c.Param("code"))
+	if errors.Is(err, database.ErrUnknownHeat) {
+		c.String(http.StatusNotFound, "Fant ikke heatet")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not get heat from db", "err", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	err = hh.DB.LeaveHeat(heat.ID, int64(c.GetInt("userId")))
+	if errors.Is(err, database.ErrHeatStarted) {
+		c.String(http.StatusOK, "Heatet har allerede startet")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not leave heat", "heatId", heat.ID, "err", err)
+		c.String(http.StatusOK, "Noe gikk galt")
+		return
+	}
+
+	showHeat(c, heat.Code)
+}
+
+func (hh HeatsHandler) startHeat(c *gin.Context) {
+	heat, err := hh.DB.RetrieveHeat(c.Param("code"))
+	if errors.Is(err, database.ErrUnknownHeat) {
+		c.String(http.StatusNotFound, "Fant ikke heatet")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not get heat from db", "err", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	userID := int64(c.GetInt("userId"))
+	if heat.OrganizerID != userID {
+		c.String(http.StatusForbidden, "Bare den som laget heatet kan starte det")
+		return
+	}
+
+	started, err := hh.DB.StartHeat(heat.ID, userID, time.Now())
+	if errors.Is(err, database.ErrEmptyHeat) {
+		c.String(http.StatusOK, "Ingen har blitt med ennå")
+		return
+	}
+	if errors.Is(err, database.ErrHeatStarted) {
+		c.String(http.StatusOK, "Heatet har allerede startet")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not start heat", "heatId", heat.ID, "err", err)
+		c.String(http.StatusOK, "Noe gikk galt")
+		return
+	}
+	for i := 0; i < started; i++ {
+		hh.Metrics.RunStarted()
+	}
+	slog.InfoContext(c.Request.Context(), "Heat started", "heatId", heat.ID, "started", started)
+
+	showHeat(c, heat.Code)
+}
+
+// Gets the heat in the url and its results, and answers the request when that
+// fails.
+func (hh HeatsHandler) heat(c *gin.Context) (database.Heat, []database.HeatResult, bool) {
+	heat, err := hh.DB.RetrieveHeat(c.Param("code"))
+	if errors.Is(err, database.ErrUnknownHeat) {
+		c.String(http.StatusNotFound, "Fant ikke heatet")
+		return heat, nil, false
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not get heat from db", "err", err)
+		c.String(http.StatusInternalServerError, "%s", err.Error())
+		return heat, nil, false
+	}
+	results, err := hh.DB.RetrieveHeatResults(viewer(c), heat.ID)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not get heat results from db", "heatId", heat.ID, "err", err)
+		c.String(http.StatusInternalServerError, "%s", err.Error())
+		return heat, nil, false
+	}
+	return heat, results, true
+}
+
+func heatStatus(c *gin.Context, message string) {
+	c.HTML(http.StatusUnprocessableEntity, "profile-status.tmpl", gin.H{
+		"message": message,
+		"error":   true,
+	})
+}
+
+func showHeat(c *gin.Context, code string) {
+	c.Header("HX-Redirect", "/heat/"+code)
+	c.Status(http.StatusOK)
+}
+
+// A heat is finished when everyone who got a leg has crossed the finish line.
+func heatFinished(heat database.Heat, results []database.HeatResult) bool {
+	if !heat.Started.Valid {
+		return false
+	}
+	for _, res := range results {
+		if res.Started && !res.ComputedTime.Valid {
+			return false
+		}
+	}
+	return true
+}
+
+func toHeatDisplay(heat database.Heat, userID int64) model.HeatDisplay {
+	d := model.HeatDisplay{
+		Code:        heat.Code,
+		Name:        heat.Name,
+		Course:      heat.Course,
+		Organizer:   heat.Organizer,
+		IsOrganizer: heat.OrganizerID == userID,
+		Started:     heat.Started.Valid,
+		Status:      "Venter på start",
+	}
+	if heat.Started.Valid {
+		d.Status = "Startet " + model.FormatDate(heat.Started.Int64)
+	}
+	return d
+}
+
+func toHeatResultDisplays(heat database.Heat, results []database.HeatResult) []model.HeatResultDisplay {
+	displays := make([]model.HeatResultDisplay, len(results))
+	place := 0
+	for i, res := range results {
+		d := model.HeatResultDisplay{Username: res.Username}
+		if d.Username == "" {
+			d.Username = "Skjult deltaker"
+		}
+		switch {
+		case res.ComputedTime.Valid:
+			place++
+			d.Place = place
+			d.Time = model.FormatTime(res.ComputedTime.Int64)
+		case res.Started:
+			d.Time = "På vei"
+		case heat.Started.Valid:
+			// They had a timer of their own running when the heat started
+			d.Time = "Startet ikke"
+		}
+		displays[i] = d
+	}
+	return displays
+}
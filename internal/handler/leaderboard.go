@@ -1,51 +1,123 @@
 package handler
 
 import (
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/KimBrusevold/webTimer/internal/achievements"
 	"github.com/KimBrusevold/webTimer/internal/database"
+	"github.com/KimBrusevold/webTimer/internal/goals"
 	"github.com/KimBrusevold/webTimer/internal/model"
+	"github.com/KimBrusevold/webTimer/internal/stats"
 	"github.com/gin-gonic/gin"
 )
 
 type LeaderboardHandler struct {
-	DB *database.TimerDB
+	DB           *database.TimerDB
+	Achievements achievements.Engine
+	Goals        goals.Tracker
+}
+
+// The leaderboard routes should run after AuthMiddelware.Identify, so users who
+// are logged in also see the times that are only shown to members.
+func viewer(c *gin.Context) database.Viewer {
+	return database.Viewer{UserID: int64(c.GetInt("userId"))}
+}
+
+// Times on different courses can not be compared, so the leaderboards of times
+// show one course, given by lop like on the start page. Without it they show
+// the first course.
+func (lh LeaderboardHandler) course(c *gin.Context) (database.Course, []database.Course, error) {
+	courses, err := lh.DB.RetrieveCourses()
+	if err != nil || len(courses) == 0 {
+		return database.Course{}, courses, err
+	}
+	courseID, err := strconv.ParseInt(c.DefaultQuery("lop", "0"), 10, 64)
+	if err != nil {
+		return database.Course{}, courses, database.ErrUnknownCourse
+	}
+	if courseID == 0 {
+		return courses[0], courses, nil
+	}
+	for _, course := range courses {
+		if course.ID == courseID {
+			return course, courses, nil
+		}
+	}
+	return database.Course{}, courses, database.ErrUnknownCourse
+}
+
+// Answers the request when the course could not be found.
+func courseError(c *gin.Context, err error) {
+	if errors.Is(err, database.ErrUnknownCourse) {
+		c.String(http.StatusNotFound, "Ukjent løype")
+		return
+	}
+	slog.ErrorContext(c.Request.Context(), "Could not get courses from db", "err", err)
+	c.String(http.StatusInternalServerError, "%s", err.Error())
 }
 
 func (lh LeaderboardHandler) HandleLeaderboardShow(c *gin.Context) {
-	times, err := lh.DB.RetrieveFastestTimeByTime(getRangeToday())
+	course, courses, err := lh.course(c)
 	if err != nil {
-		log.Printf("Could not get times from db. %s", err.Error())
+		courseError(c, err)
+		return
+	}
+
+	from, to := getRangeToday()
+	times, err := lh.DB.RetrieveFastestTimeByTime(viewer(c), course.ID, from, to)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not get times from db", "err", err)
 		c.String(http.StatusInternalServerError, "%s", err.Error())
 		return
 	}
 
-	number, err := lh.DB.RetrieveTimesCount()
+	number, err := lh.DB.RetrieveTimesCount(viewer(c))
 	if err != nil {
-		log.Printf("Could not get times count from db. %s", err.Error())
+		slog.ErrorContext(c.Request.Context(), "Could not get times count from db", "err", err)
 		c.String(http.StatusInternalServerError, "%s", err.Error())
 		return
 	}
 
-	var timesDisplay []model.TimesDisplay
+	from, to = getRangeCurrentMonth()
+	climb, err := lh.DB.RetrieveClimb(from, to)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not get climb from db", "err", err)
+		c.String(http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
 
-	for _, t := range times {
-		td := model.TimesDisplay{
-			Place:    t.Place,
-			Username: t.Username,
-			Minutes:  t.ComputedTime / (60 * 1000) % 60,
-			Seconds:  t.ComputedTime / (1000) % 60,
-			Tenths:   t.ComputedTime / (100) % 1000,
-		}
-		timesDisplay = append(timesDisplay, td)
+	divisions, err := lh.DB.RetrieveDivisions()
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not get divisions from db", "err", err)
+		c.String(http.StatusInternalServerError, "%s", err.Error())
+		return
+	}
+
+	active, err := lh.Goals.Active()
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not get goals from db", "err", err)
+		c.String(http.StatusInternalServerError, "%s", err.Error())
+		return
 	}
 
 	c.HTML(http.StatusOK, "leaderboard.tmpl", gin.H{
 		"title":      "Resultatliste",
-		"timingData": timesDisplay,
+		"timingData": toTimesDisplays(times),
 		"countData":  number,
+		"climb":      toClimbDisplay(climb),
+		"progress":   stats.Next(climb.Meters),
+		"goals":      toGoalDisplays(active),
+		"ageGroups":  database.AgeGroups,
+		"genders":    database.Genders,
+		"divisions":  divisions,
+		"course":     course,
+		"courses":    courses,
 	})
 }
 
@@ -55,59 +127,200 @@ func (lh LeaderboardHandler) RenderMostLeaderboard(c *gin.Context) {
 	var err error
 
 	if filter == "idag" {
-		times, err = lh.DB.RetrieveMostTimesByDate(getRangeToday())
+		from, to := getRangeToday()
+		times, err = lh.DB.RetrieveMostTimesByDate(viewer(c), from, to)
 	} else if filter == "noensinne" {
-		times, err = lh.DB.RetrieveTimesCount()
+		times, err = lh.DB.RetrieveTimesCount(viewer(c))
 	} else if filter == "denne-maned" {
-		times, err = lh.DB.RetrieveMostTimesByDate(getRangeCurrentMonth())
+		from, to := getRangeCurrentMonth()
+		times, err = lh.DB.RetrieveMostTimesByDate(viewer(c), from, to)
 	}
 
 	if err != nil {
-		log.Printf("Error getting fastest time %s", err)
+		slog.ErrorContext(c.Request.Context(), "Error getting most times", "err", err)
+	}
+
+	var countDisplay []model.CountDisplay
+
+	for _, t := range times {
+		countDisplay = append(countDisplay, model.CountDisplay{
+			Place:    t.Place,
+			Username: t.Username,
+			Count:    t.Count,
+			Badges:   achievements.Lookup(t.Badges),
+		})
 	}
 
 	c.HTML(http.StatusOK, "leaderboardTableMost.tmpl", gin.H{
 		"leaderboardOfHeader": "Tid",
-		"timingData":          times,
+		"timingData":          countDisplay,
 	})
 }
 
 func (lh LeaderboardHandler) RenderFastestLeaderboard(c *gin.Context) {
+	course, _, err := lh.course(c)
+	if err != nil {
+		courseError(c, err)
+		return
+	}
 	filter := c.DefaultQuery("filter", "idag")
 
 	var times []database.RetrieveTimesResponse
-	var err error
 
 	if filter == "idag" {
-		times, err = lh.DB.RetrieveFastestTimeByTime(getRangeToday())
+		from, to := getRangeToday()
+		times, err = lh.DB.RetrieveFastestTimeByTime(viewer(c), course.ID, from, to)
 	} else if filter == "noensinne" {
-		times, err = lh.DB.RetrieveAllTimeFastestTimes()
+		times, err = lh.DB.RetrieveAllTimeFastestTimes(viewer(c), course.ID)
 	} else if filter == "denne-maned" {
-		times, err = lh.DB.RetrieveFastestTimeByTime(getRangeCurrentMonth())
+		from, to := getRangeCurrentMonth()
+		times, err = lh.DB.RetrieveFastestTimeByTime(viewer(c), course.ID, from, to)
 	}
 
 	if err != nil {
-		log.Printf("Error getting fastest time %s", err)
+		slog.ErrorContext(c.Request.Context(), "Error getting fastest time", "err", err)
 	}
 
-	log.Printf("FANT: %d tider", len(times))
+	slog.DebugContext(c.Request.Context(), "Found times", "count", len(times))
 
-	var timesDisplay []model.TimesDisplay
+	c.HTML(http.StatusOK, "leaderboardTable.tmpl", gin.H{
+		"leaderboardOfHeader": "Tid",
+		"timingData":          toTimesDisplays(times),
+	})
+}
 
-	for _, t := range times {
-		td := model.TimesDisplay{
-			Place:    t.Place,
-			Username: t.Username,
-			Minutes:  t.ComputedTime / (60 * 1000) % 60,
-			Seconds:  t.ComputedTime / (1000) % 60,
-			Tenths:   t.ComputedTime / (100) % 1000,
-		}
-		timesDisplay = append(timesDisplay, td)
+// Ranks users by how much their best time this month improved on their best
+// last month, or with noensinne on the best of their first runs.
+func (lh LeaderboardHandler) RenderImprovedLeaderboard(c *gin.Context) {
+	course, _, err := lh.course(c)
+	if err != nil {
+		courseError(c, err)
+		return
+	}
+	filter := c.DefaultQuery("filter", "denne-maned")
+
+	var improved []database.ImprovementResponse
+	if filter == "noensinne" {
+		improved, err = lh.DB.RetrieveImprovementSinceStart(viewer(c), course.ID)
+	} else {
+		from, to := getRangeCurrentMonth()
+		improved, err = lh.DB.RetrieveImprovement(viewer(c), course.ID, from, to, from.AddDate(0, -1, 0))
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error getting most improved", "err", err)
+	}
+
+	var display []model.ImprovementDisplay
+	for _, i := range improved {
+		display = append(display, model.ImprovementDisplay{
+			Place:       i.Place,
+			Username:    i.Username,
+			Badges:      achievements.Lookup(i.Badges),
+			Best:        model.FormatTime(i.Best),
+			Baseline:    model.FormatTime(i.Baseline),
+			Improvement: fmt.Sprintf("%.1f %%", i.Improvement()*100),
+		})
+	}
+
+	baseline := "Forrige måned"
+	if filter == "noensinne" {
+		baseline = fmt.Sprintf("%d første løp", database.BaselineRuns)
+	}
+	c.HTML(http.StatusOK, "leaderboardTableImproved.tmpl", gin.H{
+		"baselineHeader": baseline,
+		"minRuns":        database.MinImprovedRuns,
+		"timingData":     display,
+	})
+}
+
+// Ranks the fastest times within an age group, gender or division, given like
+// alder:30-39. Without a category it is everyone.
+func (lh LeaderboardHandler) RenderCategoryLeaderboard(c *gin.Context) {
+	category, err := database.ParseCategory(c.Query("kategori"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Ukjent klasse")
+		return
+	}
+	course, _, err := lh.course(c)
+	if err != nil {
+		courseError(c, err)
+		return
+	}
+
+	from, to := getRangeToday()
+	filter := c.DefaultQuery("filter", "idag")
+	if filter == "denne-maned" {
+		from, to = getRangeCurrentMonth()
+	} else if filter == "noensinne" {
+		from, to = time.UnixMilli(0), time.Now().UTC().AddDate(0, 0, 1)
+	}
+
+	times, err := lh.DB.RetrieveFastestTimeInCategory(viewer(c), course.ID, from, to, category)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error getting fastest time in category", "category", category.String(), "err", err)
 	}
 
 	c.HTML(http.StatusOK, "leaderboardTable.tmpl", gin.H{
 		"leaderboardOfHeader": "Tid",
-		"timingData":          timesDisplay,
+		"timingData":          toTimesDisplays(times),
+	})
+}
+
+// Ranks users by how much they beat their own handicap, so everyone has a
+// chance to win.
+func (lh LeaderboardHandler) RenderHandicapLeaderboard(c *gin.Context) {
+	course, _, err := lh.course(c)
+	if err != nil {
+		courseError(c, err)
+		return
+	}
+
+	from, to := getRangeToday()
+	filter := c.DefaultQuery("filter", "idag")
+	if filter == "denne-maned" {
+		from, to = getRangeCurrentMonth()
+	} else if filter == "noensinne" {
+		from, to = time.UnixMilli(0), time.Now().UTC().AddDate(0, 0, 1)
+	}
+
+	times, err := lh.DB.RetrieveHandicapTimes(viewer(c), course.ID, from, to)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error getting handicap times", "err", err)
+	}
+
+	var display []model.HandicapDisplay
+	for _, t := range times {
+		display = append(display, model.HandicapDisplay{
+			Place:      t.Place,
+			Username:   t.Username,
+			Badges:     achievements.Lookup(t.Badges),
+			Time:       model.FormatTime(t.ComputedTime),
+			Handicap:   model.FormatTime(t.Handicap),
+			Difference: fmt.Sprintf("%+.1f %%", -t.Beat()*100),
+		})
+	}
+
+	c.HTML(http.StatusOK, "leaderboardTableHandicap.tmpl", gin.H{
+		"timingData": display,
+	})
+}
+
+func (lh LeaderboardHandler) RenderStreakLeaderboard(c *gin.Context) {
+	filter := c.DefaultQuery("filter", "dager")
+	weeks := filter == "uker"
+
+	streaks, err := lh.Achievements.StreakLeaderboard(viewer(c), weeks)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error getting streaks", "err", err)
+	}
+
+	header := "Dager"
+	if weeks {
+		header = "Uker"
+	}
+	c.HTML(http.StatusOK, "leaderboardTableStreak.tmpl", gin.H{
+		"leaderboardOfHeader": header,
+		"timingData":          streaks,
 	})
 }
 
@@ -117,7 +330,7 @@ func getRangeToday() (time.Time, time.Time) {
 	startOfDay := time.Date(currYear, currMont, currDay, 0, 0, 0, 0, now.Location())
 	startOfTomorow := startOfDay.AddDate(0, 0, 1)
 
-	log.Printf("fra: %d \n til: %d", startOfDay.UnixMilli(), startOfTomorow.UnixMilli())
+	slog.Debug("Range today", "from", startOfDay.UnixMilli(), "to", startOfTomorow.UnixMilli())
 	return startOfDay, startOfTomorow
 }
 
@@ -129,3 +342,36 @@ func getRangeCurrentMonth() (time.Time, time.Time) {
 
 	return firstOfMonth, nextMonth
 }
+
+func toTimesDisplays(times []database.RetrieveTimesResponse) []model.TimesDisplay {
+	var display []model.TimesDisplay
+	for _, t := range times {
+		display = append(display, model.TimesDisplay{
+			Place:    t.Place,
+			Username: t.Username,
+			Minutes:  t.ComputedTime / (60 * 1000) % 60,
+			Seconds:  t.ComputedTime / (1000) % 60,
+			Tenths:   t.ComputedTime / (100) % 1000,
+			Badges:   achievements.Lookup(t.Badges),
+		})
+	}
+	return display
+}
+
+func toGoalDisplays(progress []goals.Progress) []model.GoalDisplay {
+	var display []model.GoalDisplay
+	for _, p := range progress {
+		display = append(display, model.GoalDisplay{
+			ID:       p.ID,
+			Name:     p.Name,
+			Unit:     goals.Unit(p.Kind),
+			Target:   int(math.Round(p.Target)),
+			Done:     int(math.Round(p.Done)),
+			Percent:  p.Percent,
+			Starts:   time.UnixMilli(p.Starts).UTC().Format("02.01.2006"),
+			Ends:     time.UnixMilli(p.Ends - 1).UTC().Format("02.01.2006"),
+			Complete: p.Complete(),
+		})
+	}
+	return display
+}
@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/KimBrusevold/webTimer/internal/database"
+	"github.com/KimBrusevold/webTimer/internal/model"
+	"github.com/KimBrusevold/webTimer/internal/stats"
+	"github.com/gin-gonic/gin"
+)
+
+type StatsHandler struct {
+	DB *database.TimerDB
+}
+
+// The routes should run after AuthMiddelware.Identify, like the leaderboards,
+// so users are shown by their privacy settings.
+func (sh StatsHandler) SetupRoutes(rg *gin.RouterGroup) {
+	rg.GET("", sh.statsPage)
+}
+
+func (sh StatsHandler) statsPage(c *gin.Context) {
+	filter := c.DefaultQuery("filter", "denne-maned")
+	from, to := getRangeCurrentMonth()
+	if filter == "noensinne" {
+		from, to = time.UnixMilli(0), time.Now().UTC().AddDate(0, 0, 1)
+	}
+
+	total, err := sh.DB.RetrieveClimb(from, to)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not get climb from db", "err", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	v := viewer(c)
+	teams, err := sh.DB.RetrieveClimbByTeam(v, from, to)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not get climb by team from db", "err", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	users, err := sh.DB.RetrieveClimbByUser(v, from, to)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not get climb by user from db", "err", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.HTML(http.StatusOK, "stats.tmpl", gin.H{
+		"title":    "Statistikk",
+		"filter":   filter,
+		"total":    toClimbDisplay(total),
+		"progress": stats.Next(total.Meters),
+		"teams":    toClimbDisplays(teams),
+		"users":    toClimbDisplays(users),
+	})
+}
+
+func toClimbDisplay(c database.Climb) model.ClimbDisplay {
+	return model.ClimbDisplay{
+		Name:   c.Name,
+		Runs:   c.Runs,
+		Floors: c.Floors,
+		Meters: int(math.Round(c.Meters)),
+		Steps:  stats.Steps(c.Meters),
+		Kcal:   stats.Kcal(c.Meters),
+	}
+}
+
+func toClimbDisplays(climbs []database.Climb) []model.ClimbDisplay {
+	var display []model.ClimbDisplay
+	for _, c := range climbs {
+		display = append(display, toClimbDisplay(c))
+	}
+	return display
+}
@@ -1,16 +1,29 @@
 package handler
 
 import (
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/KimBrusevold/webTimer/internal/achievements"
+	"github.com/KimBrusevold/webTimer/internal/challenges"
 	"github.com/KimBrusevold/webTimer/internal/database"
+	"github.com/KimBrusevold/webTimer/internal/goals"
+	"github.com/KimBrusevold/webTimer/internal/metrics"
 	"github.com/KimBrusevold/webTimer/internal/middelware"
+	"github.com/KimBrusevold/webTimer/internal/model"
 	"github.com/gin-gonic/gin"
 )
 
 type TimerHandler struct {
-	DB *database.TimerDB
+	DB           *database.TimerDB
+	Metrics      *metrics.Metrics
+	Achievements achievements.Engine
+	Goals        goals.Tracker
+	Challenges   challenges.Service
 }
 
 func (th TimerHandler) SetupRoutes(rg *gin.RouterGroup) {
@@ -25,44 +38,169 @@ func (th TimerHandler) SetupRoutes(rg *gin.RouterGroup) {
 func (th TimerHandler) startTimerHandler(c *gin.Context) {
 	i, exists := c.Get("userId")
 	if !exists {
-		log.Print("Found no userId in context. Cannot start timer")
+		slog.ErrorContext(c.Request.Context(), "Found no userId in context. Cannot start timer")
 		c.Status(http.StatusInternalServerError)
 		return
 	}
-	err := th.DB.StartTimer(i.(int))
+	// The start page of each course has its id in the url, 0 is the first course
+	courseID, err := strconv.ParseInt(c.DefaultQuery("lop", "0"), 10, 64)
 	if err != nil {
-		log.Print("Could not start timer")
-		log.Print(err.Error())
+		c.String(http.StatusBadRequest, "Ukjent løype")
+		return
+	}
+	err = th.DB.StartTimer(i.(int), courseID)
+	if errors.Is(err, database.ErrUnknownCourse) {
+		c.String(http.StatusNotFound, "Ukjent løype")
+		return
+	}
+	// The timer that is already running keeps going
+	if err != nil && !errors.Is(err, database.ErrTimerRunning) {
+		slog.ErrorContext(c.Request.Context(), "Could not start timer", "err", err)
 		c.Status(http.StatusInternalServerError)
 		return
 	}
+	if err == nil {
+		th.Metrics.RunStarted()
+	}
+
+	run, err := th.DB.OpenTimer(int64(i.(int)))
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not get started timer", "err", err)
+		c.HTML(http.StatusOK, "tid-startet.tmpl", nil)
+		return
+	}
+	g, err := th.ghost(c, run)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not get times to race", "err", err)
+	}
 
-	c.HTML(http.StatusOK, "tid-startet.tmpl", nil)
+	// The page counts from the time on the server, as the clock on the phone may be off
+	c.HTML(http.StatusOK, "tid-startet.tmpl", gin.H{
+		"elapsed":    time.Now().UTC().UnixMilli() - run.StartTime,
+		"best":       g.best,
+		"bestTime":   model.FormatTime(g.best),
+		"leader":     g.leader.ComputedTime,
+		"leaderTime": model.FormatTime(g.leader.ComputedTime),
+		"leaderName": g.leader.Username,
+	})
+}
+
+// The times a run is raced against: the personal best on the course going into
+// the run, and the fastest time on the course today. Both are 0 when there is none.
+type ghost struct {
+	best   int64
+	leader database.RetrieveTimesResponse
+	today  []database.RetrieveTimesResponse
+}
+
+func (th TimerHandler) ghost(c *gin.Context, run database.Timer) (ghost, error) {
+	var g ghost
+	best, err := th.DB.BestTimeBefore(run)
+	if err != nil && !errors.Is(err, database.ErrNoBestTime) {
+		return g, err
+	}
+	g.best = best
+
+	from, to := getRangeToday()
+	g.today, err = th.DB.RetrieveFastestTimeByTime(viewer(c), run.CourseID, from, to)
+	if err != nil {
+		return g, err
+	}
+	if len(g.today) > 0 {
+		g.leader = g.today[0]
+	}
+	return g, nil
+}
+
+// Compares the finished run with the personal best before it, and gives the
+// place of the user today with the gap to the leader. The place is that of the
+// best run of the user today, which may be an earlier one.
+func (th TimerHandler) compare(c *gin.Context, run database.Timer) (string, string, string, error) {
+	g, err := th.ghost(c, run)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	vsBest := "Første løp på løypen"
+	if g.best > 0 {
+		gap := run.ComputedTime.Int64 - g.best
+		vsBest = formatGap(gap) + " bak persen din"
+		if gap < 0 {
+			vsBest = formatGap(gap) + " på persen din, ny pers!"
+		}
+	}
+
+	var place, vsLeader string
+	for _, t := range g.today {
+		if t.UserID != run.UserID {
+			continue
+		}
+		place = fmt.Sprintf("%d. plass i dag", t.Place)
+		if t.Place > 1 {
+			vsLeader = formatGap(t.ComputedTime-g.leader.ComputedTime) + " bak " + g.leader.Username
+		}
+	}
+	return vsBest, place, vsLeader, nil
+}
+
+// Like +3.2 s or -1.4 s.
+func formatGap(ms int64) string {
+	return fmt.Sprintf("%+.1f s", float64(ms)/1000)
 }
 
 func (th TimerHandler) endTimerHandler(c *gin.Context) {
 	i, exists := c.Get("userId")
 	if !exists {
-		log.Print("Found no userId in context. Cannot start timer")
+		slog.ErrorContext(c.Request.Context(), "Found no userId in context. Cannot stop timer")
 		c.Status(http.StatusInternalServerError)
 		return
 	}
 
 	timeUsed, err := th.DB.EndTimeTimer(i.(int))
 	if err != nil {
-		log.Print("Could not stop timer")
-		log.Print(err.Error())
+		slog.ErrorContext(c.Request.Context(), "Could not stop timer", "err", err)
 		c.Status(http.StatusInternalServerError)
 		return
 	}
+	th.Metrics.RunFinished()
+	userID := int64(i.(int))
+
+	// The run is saved, so a failure here should not hide the time from the user
+	badges, err := th.Achievements.RunFinished(userID)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not check achievements", "err", err)
+	}
+	streak, err := th.Achievements.Streak(userID)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not count streak", "err", err)
+	}
+	if err := th.Goals.Check(c.Request.Context()); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not check goals", "err", err)
+	}
+	if err := th.Challenges.RunFinished(c.Request.Context(), userID); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not resolve challenges", "err", err)
+	}
+	var vsBest, place, vsLeader string
+	run, err := th.DB.LastFinishedTimer(userID)
+	if err == nil {
+		vsBest, place, vsLeader, err = th.compare(c, run)
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not compare run", "err", err)
+	}
 
 	minutes := timeUsed / (60 * 1000) % 60
 	seconds := timeUsed / (1000) % 60
 	tenths := timeUsed / (100) % 1000
 	c.HTML(http.StatusOK, "tid-avsluttet.tmpl", gin.H{
-		"minutes": minutes,
-		"seconds": seconds,
-		"tenths":  tenths,
+		"minutes":  minutes,
+		"seconds":  seconds,
+		"tenths":   tenths,
+		"badges":   badges,
+		"streak":   streak,
+		"vsBest":   vsBest,
+		"place":    place,
+		"vsLeader": vsLeader,
 	})
 
 }
@@ -0,0 +1,114 @@
+// Package logging sets up the structured logger used by the whole app.
+//
+// Every line logged with a request context gets the id of the request, so all
+// lines from one request can be found together. Secrets and personal data are
+// redacted before they are written: attributes named like a secret are hidden,
+// and emails are masked wherever they show up.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+type contextKey struct{}
+
+// Attributes with these names, in any case, are never written.
+var secretKeys = map[string]bool{
+	"password":      true,
+	"token":         true,
+	"authtoken":     true,
+	"secret":        true,
+	"authcode":      true,
+	"code":          true,
+	"onetimecode":   true,
+	"cookie":        true,
+	"authorization": true,
+	"verifier":      true,
+}
+
+var (
+	emailPattern     = regexp.MustCompile(`([A-Za-z0-9._%+\-])[A-Za-z0-9._%+\-]*@([A-Za-z0-9.\-]+\.[A-Za-z]{2,})`)
+	authTokenPattern = regexp.MustCompile(`(?i)(authToken=)[^&\s]+`)
+)
+
+// New creates a logger writing to w. level is debug, info, warn or error, and
+// format is text or json. Empty values give info and text.
+func New(w io.Writer, level string, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if level != "" {
+		if err := lvl.UnmarshalText([]byte(level)); err != nil {
+			return nil, fmt.Errorf("invalid log level %q", level)
+		}
+	}
+
+	opts := &slog.HandlerOptions{
+		Level:       lvl,
+		ReplaceAttr: redactAttr,
+	}
+	var h slog.Handler
+	switch strings.ToLower(format) {
+	case "", "text":
+		h = slog.NewTextHandler(w, opts)
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("invalid log format %q, must be text or json", format)
+	}
+	return slog.New(handler{h}), nil
+}
+
+// WithRequestID returns a context that adds id to every line logged with it.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// RequestID returns the request id in ctx, or an empty string.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// Redact masks emails and removes auth tokens from s.
+func Redact(s string) string {
+	s = emailPattern.ReplaceAllString(s, "$1***@$2")
+	return authTokenPattern.ReplaceAllString(s, "${1}REDACTED")
+}
+
+// handler adds the request id and redacts the message before passing the record on.
+type handler struct {
+	slog.Handler
+}
+
+func (h handler) Handle(ctx context.Context, r slog.Record) error {
+	r.Message = Redact(r.Message)
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return handler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h handler) WithGroup(name string) slog.Handler {
+	return handler{h.Handler.WithGroup(name)}
+}
+
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+	if secretKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, "REDACTED")
+	}
+	switch v := a.Value.Any().(type) {
+	case string:
+		return slog.String(a.Key, Redact(v))
+	case error:
+		return slog.String(a.Key, Redact(v.Error()))
+	}
+	return a
+}
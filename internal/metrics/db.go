@@ -0,0 +1,129 @@
+package metrics
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"time"
+)
+
+// Connector wraps the database driver so the time spent on every query is
+// measured, including queries run in transactions. The query is labeled with
+// its first keyword, like select or update. With metrics turned off, the
+// connections are not wrapped.
+func (m *Metrics) Connector(d driver.Driver, dsn string) driver.Connector {
+	return &connector{metrics: m, driver: d, dsn: dsn}
+}
+
+type connector struct {
+	metrics *Metrics
+	driver  driver.Driver
+	dsn     string
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	inner, err := c.driver.Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	if c.metrics == nil {
+		return inner, nil
+	}
+	return &conn{Conn: inner, metrics: c.metrics}, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return c.driver
+}
+
+// conn passes everything on to the wrapped connection. The optional interfaces
+// answer driver.ErrSkip when the wrapped connection lacks them, which makes
+// database/sql fall back like it would without the wrapper.
+type conn struct {
+	driver.Conn
+	metrics *Metrics
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	rows, err := q.QueryContext(ctx, query, args)
+	c.observe(query, start, err)
+	return rows, err
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	result, err := e.ExecContext(ctx, query, args)
+	c.observe(query, start, err)
+	return result, err
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return p.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c *conn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *conn) CheckNamedValue(nv *driver.NamedValue) error {
+	if nc, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return nc.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+func (c *conn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *conn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c *conn) observe(query string, start time.Time, err error) {
+	if err == driver.ErrSkip {
+		return
+	}
+	c.metrics.observeQuery(operation(query), time.Since(start))
+}
+
+// operation returns the first keyword of the query, so the label has few values.
+func operation(query string) string {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return "other"
+	}
+	switch op := strings.ToLower(fields[0]); op {
+	case "select", "insert", "update", "delete", "with":
+		return op
+	}
+	return "other"
+}
@@ -0,0 +1,198 @@
+// Package metrics collects usage numbers and serves them to Prometheus.
+//
+// All methods can be called on a nil *Metrics, and then do nothing. That is how
+// metrics are turned off, so the rest of the app never has to check.
+package metrics
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "webtimer"
+
+// TimerSource counts the timers that are started but not stopped. Those started
+// before cutoff are counted as abandoned.
+type TimerSource interface {
+	OpenTimers(cutoff time.Time) (active int, abandoned int, err error)
+}
+
+type Metrics struct {
+	registry        *prometheus.Registry
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	runsStarted     prometheus.Counter
+	runsFinished    prometheus.Counter
+	signups         *prometheus.CounterVec
+	logins          *prometheus.CounterVec
+	emailFailures   prometheus.Counter
+	queryDuration   *prometheus.HistogramVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by route and status.",
+		}, []string{"method", "route", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Time spent answering HTTP requests.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		runsStarted: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "runs_started_total",
+			Help:      "Runs started.",
+		}),
+		runsFinished: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "runs_finished_total",
+			Help:      "Runs finished.",
+		}),
+		signups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "signups_total",
+			Help:      "Registrations by result, success or failure.",
+		}, []string{"result"}),
+		logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "logins_total",
+			Help:      "Logins by method, password, link or sso, and result, success or failure.",
+		}, []string{"method", "result"}),
+		emailFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "email_send_failures_total",
+			Help:      "Attempts to send an email that failed.",
+		}),
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "db_query_duration_seconds",
+			Help:      "Time spent on database queries by operation.",
+			Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+		}, []string{"operation"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.requestDuration,
+		m.runsStarted,
+		m.runsFinished,
+		m.signups,
+		m.logins,
+		m.emailFailures,
+		m.queryDuration,
+	)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	if m == nil {
+		return http.NotFoundHandler()
+	}
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// WatchTimers reports the open timers in source each time metrics are scraped.
+// Timers open for longer than abandonAfter are reported as abandoned.
+func (m *Metrics) WatchTimers(source TimerSource, abandonAfter time.Duration) {
+	if m == nil {
+		return
+	}
+	m.registry.MustRegister(&timerCollector{
+		source:       source,
+		abandonAfter: abandonAfter,
+		active: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "timers_active"),
+			"Timers started and not yet stopped.", nil, nil),
+		abandoned: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "runs_abandoned"),
+			"Runs left open for too long to be finished.", nil, nil),
+	})
+}
+
+func (m *Metrics) ObserveRequest(method string, route string, status int, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.requests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	m.requestDuration.WithLabelValues(method, route).Observe(d.Seconds())
+}
+
+func (m *Metrics) RunStarted() {
+	if m == nil {
+		return
+	}
+	m.runsStarted.Inc()
+}
+
+func (m *Metrics) RunFinished() {
+	if m == nil {
+		return
+	}
+	m.runsFinished.Inc()
+}
+
+func (m *Metrics) Signup(ok bool) {
+	if m == nil {
+		return
+	}
+	m.signups.WithLabelValues(result(ok)).Inc()
+}
+
+// Login counts a login with method, which is password, link or sso.
+func (m *Metrics) Login(method string, ok bool) {
+	if m == nil {
+		return
+	}
+	m.logins.WithLabelValues(method, result(ok)).Inc()
+}
+
+func (m *Metrics) EmailFailed() {
+	if m == nil {
+		return
+	}
+	m.emailFailures.Inc()
+}
+
+func (m *Metrics) observeQuery(operation string, d time.Duration) {
+	m.queryDuration.WithLabelValues(operation).Observe(d.Seconds())
+}
+
+func result(ok bool) string {
+	if ok {
+		return "success"
+	}
+	return "failure"
+}
+
+type timerCollector struct {
+	source       TimerSource
+	abandonAfter time.Duration
+	active       *prometheus.Desc
+	abandoned    *prometheus.Desc
+}
+
+func (tc *timerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- tc.active
+	ch <- tc.abandoned
+}
+
+func (tc *timerCollector) Collect(ch chan<- prometheus.Metric) {
+	active, abandoned, err := tc.source.OpenTimers(time.Now().UTC().Add(-tc.abandonAfter))
+	if err != nil {
+		slog.Error("Could not count open timers for metrics", "err", err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(tc.active, prometheus.GaugeValue, float64(active))
+	ch <- prometheus.MustNewConstMetric(tc.abandoned, prometheus.GaugeValue, float64(abandoned))
+}
@@ -0,0 +1,73 @@
+package middelware
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The token is kept in a cookie and has to be sent back with every post, either
+// in the csrf form field or in the X-CSRF-Token header. Another site can make the
+// browser send the cookie, but it cannot read it and put it in the request.
+const (
+	csrfCookie = "csrf"
+	csrfField  = "csrf"
+	csrfHeader = "X-CSRF-Token"
+)
+
+type CSRFMiddelware struct {
+	Cookies CookieConfig
+}
+
+// Protect checks the token on every request that is not a GET, HEAD or OPTIONS.
+// Visitors without a token get one, so pages can put it in their forms.
+func (cm *CSRFMiddelware) Protect(c *gin.Context) {
+	token, err := c.Cookie(csrfCookie)
+	if err != nil || token == "" {
+		token, err = newCSRFToken()
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Could not create CSRF token", "err", err)
+			c.Status(http.StatusInternalServerError)
+			c.Abort()
+			return
+		}
+		// Not HttpOnly. webtimer.js reads it and adds the header to htmx requests
+		cm.Cookies.Set(c, csrfCookie, token, 0, false)
+	}
+	c.Set(csrfCookie, token)
+
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return
+	}
+
+	sent := c.GetHeader(csrfHeader)
+	if sent == "" {
+		sent = c.PostForm(csrfField)
+	}
+	if subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+		slog.WarnContext(c.Request.Context(), "CSRF token did not match", "method", c.Request.Method, "path", c.Request.URL.Path)
+		c.HTML(http.StatusForbidden, "csrf-error.tmpl", gin.H{
+			"fragment": c.GetHeader("HX-Request") == "true",
+		})
+		c.Abort()
+		return
+	}
+}
+
+// CSRFToken is the token for forms that are posted without htmx.
+func CSRFToken(c *gin.Context) string {
+	return c.GetString(csrfCookie)
+}
+
+func newCSRFToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
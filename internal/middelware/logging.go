@@ -0,0 +1,54 @@
+package middelware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/KimBrusevold/webTimer/internal/logging"
+	"github.com/gin-gonic/gin"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// An id from a proxy in front of the app is kept if it looks like one.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._\-]{1,64}$`)
+
+// RequestID gives each request an id, returned in the X-Request-ID header and
+// added to every line logged with the request context. When the request is done
+// it is logged with its status and duration. The query string is left out, as
+// login links carry their token there.
+func RequestID(c *gin.Context) {
+	id := c.GetHeader(requestIDHeader)
+	if !validRequestID.MatchString(id) {
+		id = newRequestID()
+	}
+	c.Header(requestIDHeader, id)
+	ctx := logging.WithRequestID(c.Request.Context(), id)
+	c.Request = c.Request.WithContext(ctx)
+
+	start := time.Now()
+	c.Next()
+
+	level := slog.LevelInfo
+	if c.Writer.Status() >= 500 {
+		level = slog.LevelError
+	}
+	slog.Log(ctx, level, "Request",
+		"method", c.Request.Method,
+		"path", c.Request.URL.Path,
+		"status", c.Writer.Status(),
+		"duration", time.Since(start))
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		// The id only ties log lines together, so it need not be random
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
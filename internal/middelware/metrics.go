@@ -0,0 +1,25 @@
+package middelware
+
+import (
+	"time"
+
+	"github.com/KimBrusevold/webTimer/internal/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+type MetricsMiddelware struct {
+	Metrics *metrics.Metrics
+}
+
+// Observe counts the request and how long it took, labeled with the route
+// pattern rather than the path, so ids in paths don't make new series.
+func (mm *MetricsMiddelware) Observe(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	mm.Metrics.ObserveRequest(c.Request.Method, route, c.Writer.Status(), time.Since(start))
+}
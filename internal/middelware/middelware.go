@@ -1,7 +1,7 @@
 package middelware
 
 import (
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
 
@@ -16,8 +16,7 @@ type AuthMiddelware struct {
 func (amw *AuthMiddelware) Authenticate(c *gin.Context) {
 	userCookie, cErr := c.Cookie("userAuthCookie")
 	if cErr != nil {
-		log.Print("User not authenticated. Does not have userAuthCookie")
-		log.Print(cErr.Error())
+		slog.InfoContext(c.Request.Context(), "User not authenticated. Does not have userAuthCookie")
 		c.Header("Location", "/aut/innlogging")
 		c.Status(http.StatusSeeOther)
 		c.Abort()
@@ -26,8 +25,7 @@ func (amw *AuthMiddelware) Authenticate(c *gin.Context) {
 
 	idCookie, cErr := c.Cookie("userId")
 	if cErr != nil {
-		log.Print("User not authenticated. Does not have userId cookie")
-		log.Print(cErr.Error())
+		slog.InfoContext(c.Request.Context(), "User not authenticated. Does not have userId cookie")
 		c.Header("Location", "/aut/innlogging")
 		c.Status(http.StatusSeeOther)
 		c.Abort()
@@ -36,8 +34,7 @@ func (amw *AuthMiddelware) Authenticate(c *gin.Context) {
 
 	i, err := strconv.Atoi(idCookie)
 	if err != nil {
-		log.Print("Could not get id from cookie")
-		log.Print(err.Error())
+		slog.WarnContext(c.Request.Context(), "Could not get id from cookie", "err", err)
 		c.Header("Location", "/aut/innlogging")
 		c.Status(http.StatusSeeOther)
 		c.Abort()
@@ -46,7 +43,7 @@ func (amw *AuthMiddelware) Authenticate(c *gin.Context) {
 
 	isAuthenticated := amw.DB.IsAuthorizedUser(userCookie, i)
 	if !isAuthenticated {
-		log.Print("Could not find user with id and auth code")
+		slog.WarnContext(c.Request.Context(), "Could not find user with id and auth code")
 		c.Header("Location", "/aut/innlogging")
 		c.Status(http.StatusSeeOther)
 		c.Abort()
@@ -55,3 +52,49 @@ func (amw *AuthMiddelware) Authenticate(c *gin.Context) {
 
 	c.Set("userId", i)
 }
+
+// Identify sets userId like Authenticate when the user is logged in, but lets
+// everyone through. For pages that anyone can see, but that show more to users
+// who are logged in.
+func (amw *AuthMiddelware) Identify(c *gin.Context) {
+	userCookie, err := c.Cookie("userAuthCookie")
+	if err != nil {
+		return
+	}
+	idCookie, err := c.Cookie("userId")
+	if err != nil {
+		return
+	}
+	i, err := strconv.Atoi(idCookie)
+	if err != nil {
+		return
+	}
+	if amw.DB.IsAuthorizedUser(userCookie, i) {
+		c.Set("userId", i)
+	}
+}
+
+// RequireAdmin must run after Authenticate.
+func (amw *AuthMiddelware) RequireAdmin(c *gin.Context) {
+	i, exists := c.Get("userId")
+	if !exists {
+		slog.ErrorContext(c.Request.Context(), "Found no userId in context. Cannot check admin access")
+		c.Status(http.StatusInternalServerError)
+		c.Abort()
+		return
+	}
+
+	isAdmin, err := amw.DB.IsAdmin(i.(int))
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Could not check if user is admin", "err", err)
+		c.Status(http.StatusInternalServerError)
+		c.Abort()
+		return
+	}
+	if !isAdmin {
+		slog.WarnContext(c.Request.Context(), "User tried to access admin pages", "userId", i)
+		c.String(http.StatusForbidden, "Du har ikke tilgang til denne siden")
+		c.Abort()
+		return
+	}
+}
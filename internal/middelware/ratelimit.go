@@ -0,0 +1,56 @@
+package middelware
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/KimBrusevold/webTimer/internal/ratelimit"
+	"github.com/gin-gonic/gin"
+)
+
+type RateLimitMiddelware struct {
+	Store      ratelimit.Store
+	PerIP      ratelimit.Rate
+	PerAccount ratelimit.Rate // Keyed on the ip and the email or username posted in the form
+}
+
+// Limit checks the ip before the account, so requests from an ip that is
+// already limited do not count against the account they name. The account is
+// limited per ip, so no one can use up the requests of someone else by naming
+// them. Guesses spread over many ips are stopped by the lockout instead.
+func (rl *RateLimitMiddelware) Limit(c *gin.Context) {
+	ip := c.ClientIP()
+	if ok, wait := rl.Store.Take("ip:"+ip, rl.PerIP); !ok {
+		slog.WarnContext(c.Request.Context(), "Rate limited requests from an ip")
+		TooManyRequests(c, wait)
+		return
+	}
+
+	account := strings.ToLower(strings.TrimSpace(c.PostForm("email")))
+	if account == "" {
+		account = strings.ToLower(strings.TrimSpace(c.PostForm("username")))
+	}
+	if account == "" {
+		return
+	}
+	if ok, wait := rl.Store.Take("account:"+ip+":"+account, rl.PerAccount); !ok {
+		slog.WarnContext(c.Request.Context(), "Rate limited requests for an account")
+		TooManyRequests(c, wait)
+		return
+	}
+}
+
+// TooManyRequests responds with 429. htmx requests get a fragment to swap into the page.
+func TooManyRequests(c *gin.Context, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	c.Header("Retry-After", fmt.Sprintf("%d", seconds))
+	c.HTML(http.StatusTooManyRequests, "too-many-requests.tmpl", gin.H{
+		"fragment": c.GetHeader("HX-Request") == "true",
+		"minutes":  int(math.Ceil(wait.Minutes())),
+	})
+	c.Abort()
+}
@@ -0,0 +1,83 @@
+package middelware
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Only files from this site are allowed, except the Google fonts that fonts.css
+// imports. htmx has to be configured with includeIndicatorStyles false, and hx-on
+// can't be used since it needs eval. Put that code in webtimer.js instead.
+const contentSecurityPolicy = "default-src 'self'; " +
+	"script-src 'self'; " +
+	"style-src 'self' https://fonts.googleapis.com; " +
+	"font-src 'self' https://fonts.gstatic.com; " +
+	"img-src 'self' data:; " +
+	"object-src 'none'; " +
+	"base-uri 'self'; " +
+	"form-action 'self'; " +
+	"frame-ancestors 'none'"
+
+type SecurityHeadersMiddelware struct {
+	HSTS bool // Only when the site is served over https
+}
+
+func (sh *SecurityHeadersMiddelware) Apply(c *gin.Context) {
+	h := c.Writer.Header()
+	h.Set("Content-Security-Policy", contentSecurityPolicy)
+	h.Set("X-Frame-Options", "DENY")
+	h.Set("X-Content-Type-Options", "nosniff")
+	// Login links have the token in the url, so don't send it to anyone else
+	h.Set("Referrer-Policy", "same-origin")
+	if sh.HSTS {
+		h.Set("Strict-Transport-Security", "max-age=63072000")
+	}
+}
+
+// CookieConfig is how cookies are set for the site in HOSTURL.
+type CookieConfig struct {
+	Domain string
+	Secure bool
+}
+
+// NewCookieConfig derives the cookie settings from HOSTURL. localhost and ip
+// addresses get host-only cookies, and only https sites get Secure cookies.
+func NewCookieConfig(hostUrl string) (CookieConfig, error) {
+	u, err := url.Parse(BaseUrl(hostUrl))
+	if err != nil {
+		return CookieConfig{}, err
+	}
+
+	cc := CookieConfig{
+		Secure: u.Scheme == "https",
+	}
+	host := u.Hostname()
+	if host != "localhost" && net.ParseIP(host) == nil {
+		cc.Domain = host
+	}
+	return cc, nil
+}
+
+// Set sets a SameSite=Lax cookie. Lax still sends the cookie when following a
+// link to the site, so login links and bookmarks work.
+func (cc CookieConfig) Set(c *gin.Context, name string, value string, maxAge int, httpOnly bool) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(name, value, maxAge, "/", cc.Domain, cc.Secure, httpOnly)
+}
+
+// BaseUrl is HOSTURL with a scheme. HOSTURL may be given without one, then
+// localhost and 127.0.0.1 use http and everything else https.
+func BaseUrl(hostUrl string) string {
+	host := strings.TrimSuffix(hostUrl, "/")
+	if strings.HasPrefix(host, "http://") || strings.HasPrefix(host, "https://") {
+		return host
+	}
+	if strings.HasPrefix(host, "localhost") || strings.HasPrefix(host, "127.0.0.1") {
+		return "http://" + host
+	}
+	return "https://" + host
+}
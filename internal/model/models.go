@@ -1,9 +1,126 @@
 package model
 
+import (
+	"fmt"
+	"time"
+
+	"github.com/KimBrusevold/webTimer/internal/achievements"
+)
+
+// FormatTime shows a time in milliseconds like 1:05.3.
+func FormatTime(ms int64) string {
+	return fmt.Sprintf("%d:%02d.%d", ms/60000, ms/1000%60, ms/100%10)
+}
+
+// FormatDate shows the day and time of unix milliseconds, like the admin pages.
+func FormatDate(ms int64) string {
+	return time.UnixMilli(ms).Format("02.01.2006 15:04")
+}
+
 type TimesDisplay struct {
 	Place    int
 	Username string
 	Minutes  int64
 	Seconds  int64
 	Tenths   int64
+	Badges   []achievements.Badge
+}
+
+type CountDisplay struct {
+	Place    int
+	Username string
+	Count    int
+	Badges   []achievements.Badge
+}
+
+type InviteDisplay struct {
+	Code      string
+	CreatedBy string
+	Created   string
+	Expires   string
+	UsedBy    string
+	Used      bool
+}
+
+type OutboxEmailDisplay struct {
+	ID          int64
+	Recipients  string
+	Subject     string
+	Attempts    int
+	LastError   string
+	Created     string
+	NextAttempt string
+	Expired     bool // The body is cleared, so it can not be sent again
+}
+
+type HandicapDisplay struct {
+	Place      int
+	Username   string
+	Badges     []achievements.Badge
+	Time       string
+	Handicap   string
+	Difference string // Like -4.2 %, negative when faster than the handicap
+}
+
+type CourseHandicap struct {
+	Course   string
+	Handicap string
+}
+
+type ClimbDisplay struct {
+	Name   string
+	Runs   int
+	Floors int
+	Meters int
+	Steps  int
+	Kcal   int
+}
+
+type GoalDisplay struct {
+	ID       int64
+	Name     string
+	Unit     string
+	Target   int
+	Done     int
+	Percent  int
+	Starts   string
+	Ends     string // The last day of the goal
+	Complete bool
+}
+
+type ChallengeDisplay struct {
+	ID         int64
+	Challenger string
+	Opponent   string
+	Course     string
+	Target     string
+	Deadline   string
+	Status     string
+	CanAnswer  bool // The user looking at it is the opponent, and has not answered
+}
+
+type ImprovementDisplay struct {
+	Place       int
+	Username    string
+	Badges      []achievements.Badge
+	Best        string
+	Baseline    string
+	Improvement string
+}
+
+type HeatDisplay struct {
+	Code        string
+	Name        string
+	Course      string
+	Organizer   string
+	Status      string
+	IsOrganizer bool
+	Joined      bool // The user looking at it is a participant
+	Started     bool
+}
+
+type HeatResultDisplay struct {
+	Place    int // 0 until they have finished
+	Username string
+	Time     string
 }
@@ -0,0 +1,168 @@
+// Package oidc logs users in with an OpenID Connect provider, using the
+// authorization code flow with PKCE.
+//
+// Each login gets a random state, nonce and PKCE verifier. The state is checked
+// when the provider redirects back, the verifier proves that the code is exchanged
+// by the same client that started the login, and the nonce ties the ID token to
+// this login so it can't be replayed.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+var (
+	ErrStateMismatch = errors.New("oidc state does not match")
+	ErrNoIDToken     = errors.New("token response has no id_token")
+	ErrNonceMismatch = errors.New("id token nonce does not match")
+)
+
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+}
+
+// Enabled reports if single sign-on is configured.
+func (c Config) Enabled() bool {
+	return c.Issuer != "" && c.ClientID != ""
+}
+
+type Provider struct {
+	oauth    oauth2.Config
+	verifier *gooidc.IDTokenVerifier
+}
+
+// Identity is who the provider says the user is.
+type Identity struct {
+	Issuer            string
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+	Name              string
+}
+
+// Login is what has to be remembered between sending the user to the provider
+// and the user coming back.
+type Login struct {
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+// New fetches the provider's discovery document from the issuer.
+func New(ctx context.Context, cfg Config) (*Provider, error) {
+	p, err := gooidc.NewProvider(ctx, cfg.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("oidc discovery for %s: %w", cfg.Issuer, err)
+	}
+
+	return &Provider{
+		oauth: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Endpoint:     p.Endpoint(),
+			Scopes:       []string{gooidc.ScopeOpenID, "email", "profile"},
+		},
+		verifier: p.Verifier(&gooidc.Config{ClientID: cfg.ClientID}),
+	}, nil
+}
+
+func NewLogin() (Login, error) {
+	state, err := randomString()
+	if err != nil {
+		return Login{}, err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return Login{}, err
+	}
+	return Login{
+		State:    state,
+		Nonce:    nonce,
+		Verifier: oauth2.GenerateVerifier(),
+	}, nil
+}
+
+// AuthCodeURL is where the user is sent to log in.
+func (p *Provider) AuthCodeURL(l Login) string {
+	return p.oauth.AuthCodeURL(l.State,
+		gooidc.Nonce(l.Nonce),
+		oauth2.S256ChallengeOption(l.Verifier))
+}
+
+// Exchange checks the state the provider sent back, and trades the code for a
+// verified identity.
+func (p *Provider) Exchange(ctx context.Context, l Login, state string, code string) (Identity, error) {
+	if subtle.ConstantTimeCompare([]byte(state), []byte(l.State)) != 1 {
+		return Identity{}, ErrStateMismatch
+	}
+
+	token, err := p.oauth.Exchange(ctx, code, oauth2.VerifierOption(l.Verifier))
+	if err != nil {
+		return Identity{}, fmt.Errorf("oidc code exchange: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return Identity{}, ErrNoIDToken
+	}
+
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return Identity{}, fmt.Errorf("oidc id token: %w", err)
+	}
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(l.Nonce)) != 1 {
+		return Identity{}, ErrNonceMismatch
+	}
+
+	var claims struct {
+		Email             string `json:"email"`
+		EmailVerified     any    `json:"email_verified"`
+		PreferredUsername string `json:"preferred_username"`
+		Name              string `json:"name"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return Identity{}, fmt.Errorf("oidc claims: %w", err)
+	}
+
+	return Identity{
+		Issuer:            idToken.Issuer,
+		Subject:           idToken.Subject,
+		Email:             strings.TrimSpace(claims.Email),
+		EmailVerified:     isTrue(claims.EmailVerified),
+		PreferredUsername: strings.TrimSpace(claims.PreferredUsername),
+		Name:              strings.TrimSpace(claims.Name),
+	}, nil
+}
+
+// Some providers send email_verified as the string "true" instead of a boolean.
+func isTrue(v any) bool {
+	switch b := v.(type) {
+	case bool:
+		return b
+	case string:
+		return b == "true"
+	default:
+		return false
+	}
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
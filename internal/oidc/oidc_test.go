@@ -0,0 +1,251 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+const clientID = "webtimer"
+
+// mockProvider is a minimal OpenID provider. It remembers the PKCE challenge and
+// nonce from the last login, like a real provider would for the code it hands out.
+type mockProvider struct {
+	*httptest.Server
+	key       *rsa.PrivateKey
+	challenge string
+	nonce     string
+	claims    map[string]any // Added to, or replacing, the default ID token claims
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockProvider{key: key, claims: map[string]any{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"issuer":                                m.URL,
+			"authorization_endpoint":                m.URL + "/authorize",
+			"token_endpoint":                        m.URL + "/token",
+			"jwks_uri":                              m.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"keys": []map[string]any{{
+				"kty": "RSA",
+				"alg": "RS256",
+				"use": "sig",
+				"kid": "test",
+				"n":   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", m.token)
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+// authorize stands in for the user logging in at the provider, and returns the code.
+func (m *mockProvider) authorize(t *testing.T, authCodeURL string) string {
+	t.Helper()
+	u, err := url.Parse(authCodeURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" {
+		t.Fatalf("code_challenge_method = %q, want S256", q.Get("code_challenge_method"))
+	}
+	m.challenge = q.Get("code_challenge")
+	m.nonce = q.Get("nonce")
+	return "code-1"
+}
+
+func (m *mockProvider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("code") != "code-1" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != m.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	claims := map[string]any{
+		"iss":            m.URL,
+		"sub":            "user-1",
+		"aud":            clientID,
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"nonce":          m.nonce,
+		"email":          "ola@example.com",
+		"email_verified": true,
+		"name":           "Ola Nordmann",
+	}
+	for k, v := range m.claims {
+		claims[k] = v
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     m.sign(claims),
+	})
+}
+
+func (m *mockProvider) sign(claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": "test"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, sum[:])
+	if err != nil {
+		panic(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func newTestProvider(t *testing.T, m *mockProvider) *Provider {
+	t.Helper()
+	p, err := New(context.Background(), Config{
+		Issuer:       m.URL,
+		ClientID:     clientID,
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost:8080/aut/sso/callback",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestExchange(t *testing.T) {
+	m := newMockProvider(t)
+	p := newTestProvider(t, m)
+
+	login, err := NewLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := m.authorize(t, p.AuthCodeURL(login))
+
+	id, err := p.Exchange(context.Background(), login, login.State, code)
+	if err != nil {
+		t.Fatalf("Exchange: %s", err)
+	}
+	want := Identity{
+		Issuer:        m.URL,
+		Subject:       "user-1",
+		Email:         "ola@example.com",
+		EmailVerified: true,
+		Name:          "Ola Nordmann",
+	}
+	if id != want {
+		t.Errorf("Exchange = %+v, want %+v", id, want)
+	}
+}
+
+func TestExchangeRefused(t *testing.T) {
+	tests := []struct {
+		name    string
+		claims  map[string]any
+		change  func(l *Login, state *string)
+		wantErr error
+	}{
+		{
+			name:    "state from another login",
+			change:  func(l *Login, state *string) { *state = "someone-elses-state" },
+			wantErr: ErrStateMismatch,
+		},
+		{
+			name:    "wrong nonce",
+			claims:  map[string]any{"nonce": "replayed"},
+			wantErr: ErrNonceMismatch,
+		},
+		{
+			name:   "wrong PKCE verifier",
+			change: func(l *Login, state *string) { l.Verifier = "not-the-verifier-used-for-the-challenge-000" },
+		},
+		{
+			name:   "token for another client",
+			claims: map[string]any{"aud": "another-app"},
+		},
+		{
+			name:   "expired token",
+			claims: map[string]any{"exp": time.Now().Add(-time.Hour).Unix()},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockProvider(t)
+			m.claims = tt.claims
+			p := newTestProvider(t, m)
+
+			login, err := NewLogin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			code := m.authorize(t, p.AuthCodeURL(login))
+			state := login.State
+			if tt.change != nil {
+				tt.change(&login, &state)
+			}
+
+			_, err = p.Exchange(context.Background(), login, state, code)
+			if err == nil {
+				t.Fatal("Exchange succeeded, want error")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Exchange error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEmailVerifiedAsString(t *testing.T) {
+	m := newMockProvider(t)
+	m.claims = map[string]any{"email_verified": "true", "preferred_username": "ola"}
+	p := newTestProvider(t, m)
+
+	login, err := NewLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := m.authorize(t, p.AuthCodeURL(login))
+	id, err := p.Exchange(context.Background(), login, login.State, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !id.EmailVerified || id.PreferredUsername != "ola" {
+		t.Errorf("Exchange = %+v, want verified email and username ola", id)
+	}
+}
@@ -0,0 +1,72 @@
+// Package password decides if a new password is good enough.
+package password
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// bcrypt only uses the first 72 bytes of a password. Longer passwords are refused
+// instead of being cut without the user knowing.
+const MaxBytes = 72
+
+var (
+	ErrTooShort = errors.New("password is too short")
+	ErrTooLong  = errors.New("password is too long")
+	ErrCommon   = errors.New("password is too common")
+)
+
+//go:embed common-passwords.txt
+var commonPasswordList string
+
+var commonPasswords = parseList(commonPasswordList)
+
+type Policy struct {
+	MinLength int // In characters, not bytes
+}
+
+var DefaultPolicy = Policy{
+	MinLength: 8,
+}
+
+func (p Policy) Validate(password string) error {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return ErrTooShort
+	}
+	if len(password) > MaxBytes {
+		return ErrTooLong
+	}
+	if _, found := commonPasswords[strings.ToLower(password)]; found {
+		return ErrCommon
+	}
+	return nil
+}
+
+// Message explains to the user why the password was refused.
+func (p Policy) Message(err error) string {
+	switch {
+	case errors.Is(err, ErrTooShort):
+		return fmt.Sprintf("Passordet må ha minst %d tegn", p.MinLength)
+	case errors.Is(err, ErrTooLong):
+		return fmt.Sprintf("Passordet kan ikke være lengre enn %d bytes. Æ, ø, å og emojier teller som flere", MaxBytes)
+	case errors.Is(err, ErrCommon):
+		return "Passordet er for vanlig, og lett å gjette. Velg et annet"
+	default:
+		return ""
+	}
+}
+
+func parseList(list string) map[string]struct{} {
+	passwords := make(map[string]struct{})
+	for _, line := range strings.Split(list, "\n") {
+		line = strings.ToLower(strings.TrimSpace(line))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		passwords[line] = struct{}{}
+	}
+	return passwords
+}
@@ -0,0 +1,134 @@
+// Package ratelimit limits how often something can be done, using token buckets.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Rate allows Burst actions at once. Used tokens are refilled evenly over Per.
+type Rate struct {
+	Burst int
+	Per   time.Duration
+}
+
+func (r Rate) refillEvery() time.Duration {
+	return r.Per / time.Duration(r.Burst)
+}
+
+// Store holds the token buckets. MemoryStore keeps them in this process, a store shared
+// between several instances of the server can be added by implementing this interface.
+type Store interface {
+	// Take uses a token from the bucket for key. If the bucket is empty it returns false,
+	// along with how long it is until a token is available.
+	Take(key string, rate Rate) (bool, time.Duration)
+	// Peek reports what Take would, without using a token.
+	Peek(key string, rate Rate) (bool, time.Duration)
+	// Reset fills the bucket for key.
+	Reset(key string)
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	per     time.Duration
+}
+
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time
+	takes   int
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+func (s *MemoryStore) Take(key string, rate Rate) (bool, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.takes++
+	if s.takes%1000 == 0 {
+		s.removeFull()
+	}
+
+	b := s.refill(key, rate)
+	if b.tokens < 1 {
+		return false, s.wait(b, rate)
+	}
+	b.tokens--
+	return true, 0
+}
+
+func (s *MemoryStore) Peek(key string, rate Rate) (bool, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := s.refill(key, rate)
+	if b.tokens < 1 {
+		return false, s.wait(b, rate)
+	}
+	return true, 0
+}
+
+func (s *MemoryStore) Reset(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.buckets, key)
+}
+
+// refill adds the tokens earned since the bucket was last used. Missing buckets are full.
+func (s *MemoryStore) refill(key string, rate Rate) *bucket {
+	now := s.now()
+	b, exists := s.buckets[key]
+	if !exists {
+		b = &bucket{tokens: float64(rate.Burst), updated: now, per: rate.Per}
+		s.buckets[key] = b
+		return b
+	}
+
+	earned := float64(now.Sub(b.updated)) / float64(rate.refillEvery())
+	b.tokens = min(float64(rate.Burst), b.tokens+earned)
+	b.updated = now
+	return b
+}
+
+func (s *MemoryStore) wait(b *bucket, rate Rate) time.Duration {
+	return time.Duration((1 - b.tokens) * float64(rate.refillEvery()))
+}
+
+// removeFull drops buckets that have had time to refill, so the map doesn't grow forever.
+func (s *MemoryStore) removeFull() {
+	now := s.now()
+	for key, b := range s.buckets {
+		if now.Sub(b.updated) >= b.per {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// Lockout temporarily locks an account after too many failed attempts. The account can
+// fail Rate.Burst times, and gets another attempt for each Rate.Per/Rate.Burst that passes.
+type Lockout struct {
+	Store Store
+	Rate  Rate
+}
+
+func (l Lockout) Locked(account string) (bool, time.Duration) {
+	ok, wait := l.Store.Peek("lockout:"+account, l.Rate)
+	return !ok, wait
+}
+
+func (l Lockout) Failed(account string) {
+	l.Store.Take("lockout:"+account, l.Rate)
+}
+
+func (l Lockout) Succeeded(account string) {
+	l.Store.Reset("lockout:" + account)
+}
@@ -0,0 +1,90 @@
+// Package signup decides who is allowed to register.
+package signup
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+)
+
+type Mode string
+
+const (
+	Open    Mode = "open"    // Anyone can register
+	Domains Mode = "domains" // Emails from an allowed domain, or anyone with an invite code
+	Invite  Mode = "invite"  // Only people with an invite code
+)
+
+var (
+	ErrInvalidEmail = errors.New("invalid email address")
+)
+
+type Policy struct {
+	Mode            Mode
+	Domains         []string // Allowed email domains, used in Domains mode
+	RequireApproval bool     // New users must be approved by an admin before they can log in
+}
+
+func ParseMode(s string) (Mode, error) {
+	switch m := Mode(strings.ToLower(strings.TrimSpace(s))); m {
+	case Open, Domains, Invite:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown signup mode %q, must be one of open, domains or invite", s)
+}
+
+// ParseEmail parses a bare email address, and returns it along with its lower cased domain.
+func ParseEmail(s string) (string, string, error) {
+	s = strings.TrimSpace(s)
+	addr, err := mail.ParseAddress(s)
+	if err != nil || addr.Address != s || addr.Name != "" {
+		return "", "", ErrInvalidEmail
+	}
+
+	at := strings.LastIndex(addr.Address, "@")
+	if at < 1 || at == len(addr.Address)-1 {
+		return "", "", ErrInvalidEmail
+	}
+	return addr.Address, strings.ToLower(addr.Address[at+1:]), nil
+}
+
+func (p Policy) AllowsDomain(domain string) bool {
+	for _, d := range p.Domains {
+		if strings.EqualFold(d, domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// NeedsInvite reports if someone with an email in domain needs an invite code to register.
+func (p Policy) NeedsInvite(domain string) bool {
+	switch p.Mode {
+	case Open:
+		return false
+	case Domains:
+		return !p.AllowsDomain(domain)
+	default:
+		return true
+	}
+}
+
+// EmailHint describes which emails can be used, for the registration form.
+func (p Policy) EmailHint() string {
+	if p.Mode != Domains || len(p.Domains) == 0 {
+		return ""
+	}
+	return "Må være en '@" + strings.Join(p.Domains, "' eller '@") + "' adresse, eller ha en invitasjonskode"
+}
+
+// NewInviteCode creates a random code that is easy to read out and type.
+func NewInviteCode() (string, error) {
+	b := make([]byte, 5)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.EncodeToString(b), nil
+}
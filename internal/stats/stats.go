@@ -0,0 +1,58 @@
+// Package stats estimates steps and energy from the height climbed, and
+// compares the height with mountains.
+package stats
+
+import "math"
+
+const (
+	// Height of one step in a normal staircase, in meters.
+	StepHeight = 0.17
+	// Energy used to climb one meter for a person of 70 kg. Lifting 70 kg one
+	// meter takes 0.16 kcal of work, and the body is about 20% efficient.
+	KcalPerMeter = 0.8
+)
+
+type Mountain struct {
+	Name   string
+	Meters float64
+}
+
+// Mountains to climb, lowest first.
+var Mountains = []Mountain{
+	{Name: "Galdhøpiggen", Meters: 2469},
+	{Name: "Mont Blanc", Meters: 4806},
+	{Name: "Kilimanjaro", Meters: 5895},
+	{Name: "Mount Everest", Meters: 8849},
+}
+
+func Steps(meters float64) int {
+	return int(math.Round(meters / StepHeight))
+}
+
+func Kcal(meters float64) int {
+	return int(math.Round(meters * KcalPerMeter))
+}
+
+// Progress is how far up a mountain the height climbed reaches.
+type Progress struct {
+	Mountain Mountain
+	Percent  int
+	Reached  *Mountain // The highest mountain climbed, if any
+}
+
+// Next gives the progress towards the lowest mountain not yet climbed. When all
+// are climbed, it is Mount Everest at 100%.
+func Next(meters float64) Progress {
+	var p Progress
+	for i, m := range Mountains {
+		if meters < m.Meters {
+			p.Mountain = m
+			p.Percent = int(meters / m.Meters * 100)
+			return p
+		}
+		p.Reached = &Mountains[i]
+	}
+	p.Mountain = *p.Reached
+	p.Percent = 100
+	return p
+}
@@ -0,0 +1,32 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE emailoutbox(
+    id INTEGER NOT NULL PRIMARY KEY,
+    recipients TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    body TEXT NOT NULL,
+    contenttype TEXT NOT NULL,
+    state INTEGER NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    nextattempt INTEGER NOT NULL,
+    lasterror TEXT,
+    created INTEGER NOT NULL,
+    sent INTEGER
+);
+
+CREATE TABLE emailattempts(
+    id INTEGER NOT NULL PRIMARY KEY,
+    emailid INTEGER NOT NULL REFERENCES emailoutbox (id),
+    attempted INTEGER NOT NULL,
+    error TEXT
+);
+
+ALTER TABLE users ADD admin INTEGER NOT NULL DEFAULT 0;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE emailattempts;
+DROP TABLE emailoutbox;
+ALTER TABLE users DROP COLUMN admin;
+-- +goose StatementEnd
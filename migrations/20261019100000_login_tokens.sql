@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE logintokens(
+    id INTEGER NOT NULL PRIMARY KEY,
+    userid INTEGER NOT NULL REFERENCES users (id),
+    nonce TEXT NOT NULL UNIQUE,
+    expires INTEGER NOT NULL,
+    used INTEGER
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE logintokens;
+-- +goose StatementEnd
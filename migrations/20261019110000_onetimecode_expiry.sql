@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users ADD onetimecodeexpires INTEGER;
+ALTER TABLE users ADD onetimecodeattempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD onetimecodesent INTEGER;
+-- Codes are now stored hashed, so any outstanding plain text codes can't be used.
+UPDATE users SET onetimecode = NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users DROP COLUMN onetimecodesent;
+ALTER TABLE users DROP COLUMN onetimecodeattempts;
+ALTER TABLE users DROP COLUMN onetimecodeexpires;
+-- +goose StatementEnd
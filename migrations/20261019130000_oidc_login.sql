@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users ADD oidcissuer TEXT;
+ALTER TABLE users ADD oidcsubject TEXT;
+CREATE UNIQUE INDEX usersoidc ON users(oidcissuer, oidcsubject);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX usersoidc;
+ALTER TABLE users DROP COLUMN oidcsubject;
+ALTER TABLE users DROP COLUMN oidcissuer;
+-- +goose StatementEnd
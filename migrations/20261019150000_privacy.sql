@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users ADD privacy INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD alias TEXT;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users DROP COLUMN alias;
+ALTER TABLE users DROP COLUMN privacy;
+-- +goose StatementEnd
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE userachievements(
+    id INTEGER NOT NULL PRIMARY KEY,
+    userid INTEGER NOT NULL REFERENCES users (id),
+    badge TEXT NOT NULL,
+    earned INTEGER NOT NULL,
+    UNIQUE (userid, badge)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE userachievements;
+-- +goose StatementEnd
@@ -0,0 +1,29 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE courses(
+    id INTEGER NOT NULL PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    floors INTEGER NOT NULL,
+    heightmeters REAL NOT NULL
+);
+
+-- Every run so far is on the one course there was. Set its floors and height
+-- at /admin/loyper.
+INSERT INTO courses(id, name, floors, heightmeters) VALUES (1, 'Trappeløpet', 0, 0);
+ALTER TABLE times ADD courseid INTEGER REFERENCES courses (id);
+UPDATE times SET courseid = 1;
+
+CREATE TABLE teams(
+    id INTEGER NOT NULL PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE
+);
+ALTER TABLE users ADD teamid INTEGER REFERENCES teams (id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users DROP COLUMN teamid;
+DROP TABLE teams;
+ALTER TABLE times DROP COLUMN courseid;
+DROP TABLE courses;
+-- +goose StatementEnd
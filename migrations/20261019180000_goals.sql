@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE goals(
+    id INTEGER NOT NULL PRIMARY KEY,
+    name TEXT NOT NULL,
+    kind TEXT NOT NULL, -- runs or meters
+    target REAL NOT NULL,
+    starts INTEGER NOT NULL,
+    ends INTEGER NOT NULL, -- The first moment after the goal, in unix milliseconds
+    reached INTEGER -- Set when the goal is announced, so it is only announced once
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE goals;
+-- +goose StatementEnd
@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE challenges(
+    id INTEGER NOT NULL PRIMARY KEY,
+    challengerid INTEGER NOT NULL REFERENCES users (id),
+    opponentid INTEGER NOT NULL REFERENCES users (id),
+    courseid INTEGER NOT NULL REFERENCES courses (id),
+    target INTEGER NOT NULL, -- The best time of the challenger, lowered when they beat it
+    state INTEGER NOT NULL,
+    created INTEGER NOT NULL,
+    deadline INTEGER NOT NULL,
+    accepted INTEGER,
+    winnerid INTEGER REFERENCES users (id),
+    winningtime INTEGER, -- The time that beat the target, empty when the challenger won
+    resolved INTEGER
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE challenges;
+-- +goose StatementEnd
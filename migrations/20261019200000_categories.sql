@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Chosen by each user on their profile, to be ranked within their class
+ALTER TABLE users ADD agegroup TEXT;
+ALTER TABLE users ADD gender TEXT;
+ALTER TABLE users ADD division TEXT;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users DROP COLUMN division;
+ALTER TABLE users DROP COLUMN gender;
+ALTER TABLE users DROP COLUMN agegroup;
+-- +goose StatementEnd
@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE heats(
+    id INTEGER NOT NULL PRIMARY KEY,
+    code TEXT NOT NULL UNIQUE,
+    name TEXT NOT NULL,
+    courseid INTEGER NOT NULL REFERENCES courses (id),
+    organizerid INTEGER NOT NULL REFERENCES users (id),
+    created INTEGER NOT NULL,
+    started INTEGER -- The start time shared by everyone in the heat
+);
+
+CREATE TABLE heatparticipants(
+    heatid INTEGER NOT NULL REFERENCES heats (id),
+    userid INTEGER NOT NULL REFERENCES users (id),
+    timeid INTEGER REFERENCES times (id), -- The leg of the participant, set when the heat starts
+    UNIQUE (heatid, userid)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE heatparticipants;
+DROP TABLE heats;
+-- +goose StatementEnd
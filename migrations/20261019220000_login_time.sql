@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+-- When the authcode was made, which is when the user last logged in.
+ALTER TABLE users ADD authcodecreated INTEGER;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users DROP COLUMN authcodecreated;
+-- +goose StatementEnd
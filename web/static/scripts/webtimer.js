@@ -0,0 +1,71 @@
+// htmx leaves error responses out of the page by default. These responses are
+// written to be shown to the user, so swap them in like any other response.
+document.addEventListener("htmx:beforeSwap", function (evt) {
+  const status = evt.detail.xhr.status;
+  if (status === 403 || status === 422 || status === 429) {
+    evt.detail.shouldSwap = true;
+    evt.detail.isError = false;
+  }
+});
+
+// Send the CSRF token from the cookie with every htmx request. Forms posted
+// without htmx have the token in a hidden field instead.
+document.addEventListener("htmx:configRequest", function (evt) {
+  const match = document.cookie.match(/(?:^|;\s*)csrf=([^;]*)/);
+  if (match) {
+    evt.detail.headers["X-CSRF-Token"] = decodeURIComponent(match[1]);
+  }
+});
+
+// Marks a leaderboard tab as selected once its content has loaded.
+document.addEventListener("htmx:afterOnLoad", function (evt) {
+  const newTab = evt.target;
+  const tabs = newTab.closest(".tabs");
+  if (!tabs || newTab.getAttribute("role") !== "tab") {
+    return;
+  }
+  const currentTab = tabs.querySelector("[aria-selected=true]");
+  if (currentTab) {
+    currentTab.setAttribute("aria-selected", "false");
+    currentTab.removeAttribute("disabled");
+    currentTab.classList.remove("selected");
+  }
+  newTab.setAttribute("aria-selected", "true");
+  newTab.setAttribute("disabled", "true");
+  newTab.classList.add("selected");
+});
+
+// Runs the clock on the page shown while a run is going, and compares it with
+// the personal best and the fastest time today. The server gives the time
+// since the start, so the clock on the phone doesn't matter.
+document.addEventListener("DOMContentLoaded", function () {
+  const clock = document.querySelector(".ghost-clock");
+  if (!clock) {
+    return;
+  }
+  const loaded = performance.now() - Number(clock.dataset.elapsed);
+  const format = function (ms) {
+    const tenths = Math.floor(ms / 100);
+    const seconds = Math.floor(tenths / 10) % 60;
+    const minutes = Math.floor(tenths / 600);
+    return minutes + ":" + (seconds < 10 ? "0" : "") + seconds + "." + (tenths % 10);
+  };
+  const compare = function (el, target, elapsed) {
+    if (!el || !target) {
+      return;
+    }
+    if (elapsed < target) {
+      el.textContent = "(" + format(target - elapsed) + " igjen)";
+    } else {
+      el.textContent = "(+" + format(elapsed - target) + " bak)";
+    }
+  };
+  const tick = function () {
+    const elapsed = performance.now() - loaded;
+    clock.querySelector(".ghost-time").textContent = format(elapsed);
+    compare(clock.querySelector(".ghost-best"), Number(clock.dataset.best), elapsed);
+    compare(clock.querySelector(".ghost-leader"), Number(clock.dataset.leader), elapsed);
+  };
+  tick();
+  setInterval(tick, 100);
+});
@@ -0,0 +1,7 @@
+// Package web holds the templates and static files, built into the binary.
+package web
+
+import "embed"
+
+//go:embed pages/template static
+var Files embed.FS